@@ -0,0 +1,115 @@
+package frames
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func startBenchServer(b *testing.B) ChannelDialer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Error listening: %v", err)
+	}
+	ll, err := ListenerListener(l)
+	if err != nil {
+		b.Fatalf("Error listen listening: %v", err)
+	}
+	b.Cleanup(func() { ll.Close() })
+	go func() {
+		for {
+			c, err := ll.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatalf("Error connecting: %v", err)
+	}
+	d := NewClient(c)
+	b.Cleanup(func() { d.Close() })
+	return d
+}
+
+func benchRoundTrip(b *testing.B, size int) {
+	d := startBenchServer(b)
+	c, err := d.Dial()
+	if err != nil {
+		b.Fatalf("Error dialing: %v", err)
+	}
+	defer c.Close()
+
+	msg := make([]byte, size)
+	buf := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(msg); err != nil {
+			b.Fatalf("Error writing: %v", err)
+		}
+		if _, err := io.ReadFull(c, buf); err != nil {
+			b.Fatalf("Error reading: %v", err)
+		}
+	}
+}
+
+func BenchmarkRoundTrip64(b *testing.B) {
+	benchRoundTrip(b, 64)
+}
+
+func BenchmarkRoundTrip1024(b *testing.B) {
+	benchRoundTrip(b, 1024)
+}
+
+func BenchmarkRoundTrip32768(b *testing.B) {
+	benchRoundTrip(b, 32768)
+}
+
+func BenchmarkRoundTrip262144(b *testing.B) {
+	benchRoundTrip(b, 262144)
+}
+
+func BenchmarkOpenClose(b *testing.B) {
+	d := startBenchServer(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := d.Dial()
+		if err != nil {
+			b.Fatalf("Error dialing: %v", err)
+		}
+		c.Close()
+	}
+}
+
+func BenchmarkParallelRoundTrip1024(b *testing.B) {
+	d := startBenchServer(b)
+	b.SetBytes(1024)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c, err := d.Dial()
+		if err != nil {
+			b.Errorf("Error dialing: %v", err)
+			return
+		}
+		defer c.Close()
+		msg := make([]byte, 1024)
+		buf := make([]byte, 1024)
+		for pb.Next() {
+			if _, err := c.Write(msg); err != nil {
+				b.Errorf("Error writing: %v", err)
+				return
+			}
+			if _, err := io.ReadFull(c, buf); err != nil {
+				b.Errorf("Error reading: %v", err)
+				return
+			}
+		}
+	})
+}
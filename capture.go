@@ -0,0 +1,452 @@
+package frames
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture files begin with this magic, followed by records of:
+//
+// 8 bytes timestamp (unix nanoseconds),
+// 4 bytes session ID,
+// 1 byte direction,
+// the packet as sent on the wire.
+const captureMagic = "frmscap2"
+
+// ErrNotCapture is returned when reading something that isn't a
+// capture file.
+var ErrNotCapture = errors.New("not a frames capture")
+
+// Direction is the direction of a captured packet relative to the
+// recording side of a connection.
+type Direction uint8
+
+const (
+	// Inbound packets were read by the recording side.
+	Inbound = Direction(iota)
+	// Outbound packets were written by the recording side.
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "<-"
+	case Outbound:
+		return "->"
+	}
+	return fmt.Sprintf("{Direction 0x%x}", int(d))
+}
+
+// A CaptureRecord is a single captured packet.
+type CaptureRecord struct {
+	Time time.Time
+	// Session distinguishes the connections recorded to a single
+	// capture.
+	Session uint32
+	Dir     Direction
+	Packet  *FramePacket
+}
+
+func (r CaptureRecord) String() string {
+	return fmt.Sprintf("%v #%d %v %v",
+		r.Time.Format("15:04:05.000000"), r.Session, r.Dir, r.Packet)
+}
+
+// A CaptureWriter writes capture records to a stream.  It's safe for
+// concurrent use.
+type CaptureWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      []byte
+	sessions atomic.Uint32
+}
+
+// NewCaptureWriter writes a capture header to w and returns a
+// CaptureWriter for writing records after it.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteRecord writes a single record.
+func (cw *CaptureWriter) WriteRecord(r CaptureRecord) error {
+	if len(r.Packet.Data) > maxWriteLen {
+		return ErrPacketTooLarge
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.buf = cw.buf[:0]
+	cw.buf = binary.BigEndian.AppendUint64(cw.buf, uint64(r.Time.UnixNano()))
+	cw.buf = binary.BigEndian.AppendUint32(cw.buf, r.Session)
+	cw.buf = append(cw.buf, byte(r.Dir))
+	cw.buf = append(cw.buf, r.Packet.Bytes()...)
+	_, err := cw.w.Write(cw.buf)
+	return err
+}
+
+// A CaptureReader reads capture records from a stream.
+type CaptureReader struct {
+	r   io.Reader
+	dec *Decoder
+}
+
+// NewCaptureReader verifies the capture header from r and returns a
+// CaptureReader for the records following it.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrNotCapture
+		}
+		return nil, err
+	}
+	if string(magic) != captureMagic {
+		return nil, ErrNotCapture
+	}
+	return &CaptureReader{r: r, dec: NewDecoder(r)}, nil
+}
+
+// ReadRecord reads the next record, returning io.EOF at the end of
+// the capture.
+func (cr *CaptureReader) ReadRecord() (CaptureRecord, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+		return CaptureRecord{}, err
+	}
+	pkt, err := cr.dec.ReadPacket()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return CaptureRecord{}, err
+	}
+	return CaptureRecord{
+		Time:    time.Unix(0, int64(binary.BigEndian.Uint64(hdr[:]))),
+		Session: binary.BigEndian.Uint32(hdr[8:]),
+		Dir:     Direction(hdr[12]),
+		Packet:  pkt,
+	}, nil
+}
+
+// packetScanner reassembles packets from arbitrarily split stream
+// data.
+type packetScanner struct {
+	buf    []byte
+	broken bool
+}
+
+func (s *packetScanner) feed(b []byte, emit func(*FramePacket)) {
+	if s.broken {
+		return
+	}
+	s.buf = append(s.buf, b...)
+	for len(s.buf) >= minPktLen {
+		pkt, err := DecodeHeader(s.buf)
+		if err != nil {
+			// Not a frames stream; stop trying.
+			s.broken = true
+			s.buf = nil
+			return
+		}
+		end := minPktLen + len(pkt.Data)
+		if len(s.buf) < end {
+			return
+		}
+		copy(pkt.Data, s.buf[minPktLen:end])
+		emit(&pkt)
+		s.buf = s.buf[end:]
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
+
+// A RecordingConn is a net.Conn that records every packet read from
+// and written to the underlying connection.
+type RecordingConn struct {
+	net.Conn
+	cw      *CaptureWriter
+	session uint32
+
+	mu       sync.Mutex
+	in, out  packetScanner
+	inMu     sync.Mutex
+	outMu    sync.Mutex
+	firstErr error
+}
+
+// NewRecordingConn wraps c, recording its traffic to cw.  Errors
+// writing the capture stop recording, but don't affect c.
+//
+// Each RecordingConn sharing a CaptureWriter records under its own
+// session ID, numbered from 1.
+func NewRecordingConn(c net.Conn, cw *CaptureWriter) *RecordingConn {
+	return &RecordingConn{Conn: c, cw: cw, session: cw.sessions.Add(1)}
+}
+
+// Session returns the session ID this connection's records carry.
+func (r *RecordingConn) Session() uint32 {
+	return r.session
+}
+
+// Err returns the first error encountered writing the capture.
+func (r *RecordingConn) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.firstErr
+}
+
+func (r *RecordingConn) record(dir Direction, pkt *FramePacket) {
+	if r.Err() != nil {
+		return
+	}
+	err := r.cw.WriteRecord(CaptureRecord{time.Now(), r.session, dir, pkt})
+	if err != nil {
+		r.mu.Lock()
+		if r.firstErr == nil {
+			r.firstErr = err
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *RecordingConn) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	r.inMu.Lock()
+	r.in.feed(b[:n], func(p *FramePacket) { r.record(Inbound, p) })
+	r.inMu.Unlock()
+	return n, err
+}
+
+func (r *RecordingConn) Write(b []byte) (int, error) {
+	n, err := r.Conn.Write(b)
+	r.outMu.Lock()
+	r.out.feed(b[:n], func(p *FramePacket) { r.record(Outbound, p) })
+	r.outMu.Unlock()
+	return n, err
+}
+
+// ReplayOptions configure Replay.
+type ReplayOptions struct {
+	// Timing, if true, reproduces the delays between captured
+	// packets.  Otherwise packets are sent as fast as possible.
+	Timing bool
+	// Settle is how long to wait for further responses after the
+	// last packet is sent.  Default is 100ms.
+	Settle time.Duration
+	// Session selects the recorded session to replay.  Default is
+	// the first session in the capture.
+	Session uint32
+}
+
+// A ReplayReport describes the outcome of a Replay.
+type ReplayReport struct {
+	Sent     int
+	Received int
+	// Mismatches describe channels whose responses differed from
+	// the capture.
+	Mismatches []string
+}
+
+type replayReader struct {
+	mu       sync.Mutex
+	data     map[uint16][]byte
+	received int
+	opens    chan *FramePacket
+	activity chan bool
+	done     chan error
+	stop     chan bool
+}
+
+func (rr *replayReader) run(c net.Conn) {
+	dec := NewDecoder(c)
+	for {
+		pkt, err := dec.ReadPacket()
+		if err != nil {
+			rr.done <- err
+			return
+		}
+		rr.mu.Lock()
+		rr.received++
+		if pkt.Cmd == FrameData {
+			rr.data[pkt.Channel] = append(rr.data[pkt.Channel], pkt.Data...)
+		}
+		rr.mu.Unlock()
+		if pkt.Cmd == FrameOpen {
+			select {
+			case rr.opens <- pkt:
+			case <-rr.stop:
+				return
+			}
+		}
+		select {
+		case rr.activity <- true:
+		default:
+		}
+	}
+}
+
+// awaitData waits until n bytes have arrived on channel, or nothing
+// has arrived for settle.
+func (rr *replayReader) awaitData(channel uint16, n int, settle time.Duration) {
+	t := time.NewTimer(settle)
+	defer t.Stop()
+	for {
+		rr.mu.Lock()
+		got := len(rr.data[channel])
+		rr.mu.Unlock()
+		if got >= n {
+			return
+		}
+		select {
+		case <-rr.activity:
+			t.Reset(settle)
+		case <-t.C:
+			return
+		}
+	}
+}
+
+// Replay plays the outbound side of a capture recorded on a client
+// against a server on c, then compares the data the server sends on
+// each channel with the capture.
+//
+// Channel IDs are translated from the captured ones to the ones
+// assigned by the server, so a replay may be compared against a
+// capture from any session.  Closes wait for the channel's captured
+// responses, up to Settle, since the server discards anything
+// written after them.
+func Replay(cr *CaptureReader, c net.Conn, opts ReplayOptions) (*ReplayReport, error) {
+	if opts.Settle == 0 {
+		opts.Settle = 100 * time.Millisecond
+	}
+
+	var records []CaptureRecord
+	for {
+		r, err := cr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.Session == 0 {
+			opts.Session = r.Session
+		}
+		if r.Session == opts.Session {
+			records = append(records, r)
+		}
+	}
+
+	// The capture's channel IDs in the order they were opened,
+	// and the data expected on each.
+	var capturedIDs []uint16
+	expected := map[uint16][]byte{}
+	for _, r := range records {
+		if r.Dir != Inbound {
+			continue
+		}
+		switch r.Packet.Cmd {
+		case FrameOpen:
+			capturedIDs = append(capturedIDs, r.Packet.Channel)
+		case FrameData:
+			expected[r.Packet.Channel] = append(expected[r.Packet.Channel], r.Packet.Data...)
+		}
+	}
+
+	rr := &replayReader{
+		data:     map[uint16][]byte{},
+		opens:    make(chan *FramePacket, 1),
+		activity: make(chan bool, 1),
+		done:     make(chan error, 1),
+		stop:     make(chan bool),
+	}
+	defer close(rr.stop)
+	go rr.run(c)
+
+	report := &ReplayReport{}
+	enc := NewEncoder(c)
+	live := map[uint16]uint16{}
+	opened := 0
+	var prev time.Time
+	for _, r := range records {
+		if r.Dir != Outbound {
+			continue
+		}
+		if opts.Timing && !prev.IsZero() {
+			time.Sleep(r.Time.Sub(prev))
+		}
+		prev = r.Time
+
+		pkt := *r.Packet
+		if pkt.Cmd != FrameOpen {
+			id, ok := live[pkt.Channel]
+			if !ok {
+				return report, fmt.Errorf("captured %v on unopened channel", r.Packet)
+			}
+			pkt.Channel = id
+			if pkt.Cmd == FrameClose {
+				rr.awaitData(id, len(expected[r.Packet.Channel]), opts.Settle)
+			}
+		}
+		if err := enc.WritePacket(&pkt); err != nil {
+			return report, err
+		}
+		report.Sent++
+
+		if pkt.Cmd == FrameOpen {
+			var reply *FramePacket
+			select {
+			case reply = <-rr.opens:
+			case err := <-rr.done:
+				return report, err
+			}
+			if opened < len(capturedIDs) {
+				live[capturedIDs[opened]] = reply.Channel
+			}
+			opened++
+		}
+	}
+
+	t := time.NewTimer(opts.Settle)
+	defer t.Stop()
+settle:
+	for {
+		select {
+		case <-rr.activity:
+			t.Reset(opts.Settle)
+		case <-rr.done:
+			break settle
+		case <-t.C:
+			break settle
+		}
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	report.Received = rr.received
+	for _, id := range capturedIDs {
+		lid, ok := live[id]
+		if !ok {
+			report.Mismatches = append(report.Mismatches,
+				fmt.Sprintf("channel %d was never opened", id))
+			continue
+		}
+		if got := rr.data[lid]; !bytes.Equal(got, expected[id]) {
+			report.Mismatches = append(report.Mismatches,
+				fmt.Sprintf("channel %d (now %d): expected %d bytes, got %d bytes",
+					id, lid, len(expected[id]), len(got)))
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,165 @@
+package frames
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	t.Parallel()
+	var b bytes.Buffer
+	cw, err := NewCaptureWriter(&b)
+	if err != nil {
+		t.Fatalf("Error creating capture writer: %v", err)
+	}
+	now := time.Unix(1234, 5678)
+	recs := []CaptureRecord{
+		{now, 1, Outbound, &FramePacket{Cmd: FrameOpen, Data: []byte{}}},
+		{now.Add(time.Millisecond), 1, Inbound, &FramePacket{Cmd: FrameOpen, Channel: 1, Data: []byte{}}},
+		{now.Add(time.Second), 7, Outbound, &FramePacket{Cmd: FrameData, Channel: 1, Data: []byte("hi")}},
+	}
+	for _, r := range recs {
+		if err := cw.WriteRecord(r); err != nil {
+			t.Fatalf("Error writing %v: %v", r, err)
+		}
+	}
+
+	cr, err := NewCaptureReader(&b)
+	if err != nil {
+		t.Fatalf("Error creating capture reader: %v", err)
+	}
+	for _, exp := range recs {
+		got, err := cr.ReadRecord()
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if got.String() != exp.String() || !got.Time.Equal(exp.Time) {
+			t.Errorf("Expected %v, got %v", exp, got)
+		}
+	}
+	if _, err := cr.ReadRecord(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+
+	if _, err := NewCaptureReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n"))); err != ErrNotCapture {
+		t.Errorf("Expected ErrNotCapture, got %v", err)
+	}
+}
+
+func TestPacketScanner(t *testing.T) {
+	t.Parallel()
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		stream = append(stream, FramePacket{Cmd: FrameData, Channel: uint16(i),
+			Data: []byte(fmt.Sprintf("packet %d", i))}.Bytes()...)
+	}
+
+	var s packetScanner
+	var got []*FramePacket
+	for _, b := range stream {
+		s.feed([]byte{b}, func(p *FramePacket) { got = append(got, p) })
+	}
+	if len(got) != 3 || string(got[2].Data) != "packet 2" {
+		t.Errorf("Unexpected packets: %v", got)
+	}
+}
+
+// Record a client session against an echo server, then replay it.
+func TestRecordAndReplay(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc := runTestEchoServer(t)
+	defer tc.l.Close()
+
+	c, err := net.Dial("tcp", tc.addr)
+	if err != nil {
+		t.Fatalf("Error connecting to my server: %v", err)
+	}
+
+	var b bytes.Buffer
+	cw, err := NewCaptureWriter(&b)
+	if err != nil {
+		t.Fatalf("Error creating capture writer: %v", err)
+	}
+	rc := NewRecordingConn(c, cw)
+	fc := NewClient(rc)
+
+	for i := 0; i < 2; i++ {
+		ch, err := fc.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		msg := fmt.Sprintf("hello %d", i)
+		fmt.Fprint(ch, msg)
+		if _, err := io.ReadFull(ch, make([]byte, len(msg))); err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		ch.Close()
+	}
+	fc.Close()
+	if err := rc.Err(); err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+
+	// A second session on the same capture must not leak into the
+	// first one's replay.
+	c, err = net.Dial("tcp", tc.addr)
+	if err != nil {
+		t.Fatalf("Error connecting to my server: %v", err)
+	}
+	rc2 := NewRecordingConn(c, cw)
+	if rc.Session() == rc2.Session() {
+		t.Fatalf("Expected distinct sessions, got %v twice", rc.Session())
+	}
+	fc = NewClient(rc2)
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	fmt.Fprint(ch, "other session")
+	if _, err := io.ReadFull(ch, make([]byte, len("other session"))); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	fc.Close()
+
+	capture := append([]byte(nil), b.Bytes()...)
+	cr, err := NewCaptureReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatalf("Error reading capture: %v", err)
+	}
+	var dirs [2]int
+	for {
+		r, err := cr.ReadRecord()
+		if err != nil {
+			break
+		}
+		if r.Session == rc.Session() {
+			dirs[r.Dir]++
+		}
+	}
+	// Two opens, two writes and at least one close (the last may
+	// be abandoned by the client's Close) out; two opens and two
+	// echos in.
+	if dirs[Outbound] < 5 || dirs[Inbound] < 4 {
+		t.Errorf("Unexpected capture directions: %v", dirs)
+	}
+
+	c, err = net.Dial("tcp", tc.addr)
+	if err != nil {
+		t.Fatalf("Error connecting to my server: %v", err)
+	}
+	defer c.Close()
+	cr, _ = NewCaptureReader(bytes.NewReader(capture))
+	report, err := Replay(cr, c, ReplayOptions{Settle: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error replaying: %v", err)
+	}
+	if report.Sent != dirs[Outbound] || len(report.Mismatches) != 0 {
+		t.Errorf("Unexpected replay report: %+v", report)
+	}
+}
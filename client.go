@@ -1,11 +1,14 @@
 package frames
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,10 +28,16 @@ type Info struct {
 }
 
 var (
-	errClosedConn    = errors.New("closed connection")
+	// ErrSessionClosed is returned by operations on a session, or
+	// its channels, after the session is closed or lost.
+	ErrSessionClosed = errors.New("closed connection")
+
 	errClosedReadCh  = errors.New("read on closed channel")
 	errClosedWriteCh = errors.New("write on closed channel")
-	errNotImpl       = errors.New("not implemented")
+
+	// ErrIdleTimeout is returned by reads and writes on a channel the
+	// server reset for being idle too long.
+	ErrIdleTimeout = errors.New("idle timeout")
 )
 
 func (i Info) String() string {
@@ -42,105 +51,201 @@ type queueResult struct {
 }
 
 type frameClient struct {
-	c           net.Conn
-	channels    map[uint16]*clientChannel
-	egress      chan *FramePacket
-	closeMarker chan bool
-	connqueue   chan chan queueResult
-	info        Info
+	c            net.Conn
+	mu           sync.Mutex
+	channels     map[uint16]*clientChannel
+	quarantine   map[uint16]bool // closed, awaiting the server's ack
+	egress       chan *FramePacket
+	closeMarker  chan bool
+	closeOnce    sync.Once
+	cause        error
+	connqueue    chan chan queueResult
+	info         Info
+	pending      int64 // bytes being written by channels
+	maxWriteLen  int
+	writeTimeout time.Duration
+	log          *slog.Logger
+	session      SessionInfo
+	hooks        hookRunner
+	metrics      *Metrics
+	protocols    []string
+	offer        []byte // protocols, as offered on open
 }
 
 func (fc *frameClient) GetInfo() Info {
-	rv := fc.info
-	rv.ChannelsOpen = len(fc.channels)
-	return rv
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return Info{
+		BytesRead:    atomic.LoadUint64(&fc.info.BytesRead),
+		BytesWritten: atomic.LoadUint64(&fc.info.BytesWritten),
+		ChannelsOpen: len(fc.channels),
+	}
+}
+
+func (fc *frameClient) state() SessionState {
+	fc.mu.Lock()
+	channels := make([]ChannelState, 0, len(fc.channels))
+	for _, ch := range fc.channels {
+		channels = append(channels, ch.state(ch.String(), ch.channel))
+	}
+	fc.mu.Unlock()
+	sortChannels(channels)
+	return SessionState{
+		LocalAddr:  fc.c.LocalAddr().String(),
+		RemoteAddr: fc.c.RemoteAddr().String(),
+		Info:       fc.GetInfo(),
+		Channels:   channels,
+	}
 }
 
-func (fc *frameClient) handleOpened(pkt *FramePacket) {
+func (fc *frameClient) handleOpened(pkt *FramePacket) error {
 	var opening chan queueResult
 	select {
 	case opening = <-fc.connqueue:
 	default:
-		log.Panicf("Opening response, but nobody's opening")
+		return protocolErrorf("open response for channel %d, but nobody's opening",
+			pkt.Channel)
 	}
 
 	if pkt.Status != FrameSuccess {
 		err := frameError(*pkt)
+		fc.hooks.openRejected(fc.session, err)
+		fc.metrics.openRejected()
 		select {
 		case opening <- queueResult{err: err}:
 		case <-fc.closeMarker:
 		}
-		return
+		return nil
 	}
 
-	fc.channels[pkt.Channel] = &clientChannel{
-		fc,
-		pkt.Channel,
-		make(chan []byte),
-		nil,
-		make(chan bool),
+	ch := &clientChannel{
+		fc:           fc,
+		channel:      pkt.Channel,
+		incoming:     make(chan []byte),
+		closeMarker:  make(chan bool),
+		channelStats: newChannelStats(),
+	}
+	ch.protocol = negotiate(pkt.Data, fc.protocols)
+	ch.closeAck = offersCloseAck(pkt.Data)
+	fc.mu.Lock()
+	_, inUse := fc.channels[pkt.Channel]
+	if !inUse {
+		fc.channels[pkt.Channel] = ch
+		// A server that doesn't acknowledge closes reuses IDs
+		// without one.
+		delete(fc.quarantine, pkt.Channel)
+	}
+	fc.mu.Unlock()
+	if inUse {
+		err := protocolErrorf("open response for channel %d already in use",
+			pkt.Channel)
+		select {
+		case opening <- queueResult{err: err}:
+		case <-fc.closeMarker:
+		}
+		return err
 	}
+
+	fc.hooks.channelOpened(ch.info(fc.session, ch.channel))
+	fc.metrics.channelOpened()
 	select {
-	case opening <- queueResult{fc.channels[pkt.Channel], nil}:
+	case opening <- queueResult{ch, nil}:
 	case <-fc.closeMarker:
 	}
+	return nil
 }
 
 func (fc *frameClient) handleClosed(pkt *FramePacket) {
-	log.Panicf("Closing channel on %v %v (unhandled)",
-		fc.c.LocalAddr(), pkt.Channel)
+	fc.mu.Lock()
+	ch := fc.channels[pkt.Channel]
+	delete(fc.channels, pkt.Channel)
+	acked := fc.quarantine[pkt.Channel]
+	delete(fc.quarantine, pkt.Channel)
+	fc.mu.Unlock()
+	if acked {
+		return
+	}
+	if ch == nil {
+		fc.log.Warn("close of non-existent channel", pktAttrs(pkt))
+		fc.metrics.strayFrame()
+		return
+	}
+	ch.terminateWith(resetReason(pkt))
+	// Acknowledge the server's close so it may reuse the ID.
+	select {
+	case fc.egress <- &FramePacket{
+		Cmd:     FrameClose,
+		Channel: pkt.Channel,
+		rch:     make(chan error, 1),
+	}:
+	case <-fc.closeMarker:
+	}
 }
 
 func (fc *frameClient) handleData(pkt *FramePacket) {
+	fc.mu.Lock()
 	ch := fc.channels[pkt.Channel]
+	quarantined := fc.quarantine[pkt.Channel]
+	fc.mu.Unlock()
 	if ch == nil {
-		log.Printf("Data on non-existent channel on %v %v: %v",
-			fc.c.LocalAddr(), ch, pkt)
+		if quarantined {
+			fc.log.Debug("data on closing channel", pktAttrs(pkt))
+		} else {
+			fc.log.Warn("data on non-existent channel", pktAttrs(pkt))
+		}
+		fc.metrics.strayFrame()
 		return
 	}
 
 	select {
 	case ch.incoming <- pkt.Data:
+		ch.countDelivered(len(pkt.Data))
 	case <-ch.closeMarker:
-		log.Printf("Data on closed channel on %v: %v: %v",
-			fc.c.LocalAddr(), ch, pkt)
+		fc.log.Debug("data on closed channel", pktAttrs(pkt))
 	}
 }
 
 func (fc *frameClient) readResponses() {
-	defer fc.Close()
+	var err error
+	defer func() { fc.closeWith(err) }()
+	dec := NewDecoder(countingReader{fc.c, &fc.info.BytesRead})
 	for {
-		hdr := make([]byte, minPktLen)
-		r, err := io.ReadFull(fc.c, hdr)
-		fc.info.BytesRead += uint64(r)
-		if err != nil {
-			log.Printf("Error reading pkt header from %v: %v",
-				fc.c.RemoteAddr(), err)
-			return
-		}
-		pkt := PacketFromHeader(hdr)
-		r, err = io.ReadFull(fc.c, pkt.Data)
-		fc.info.BytesRead += uint64(r)
+		var pkt *FramePacket
+		pkt, err = dec.ReadPacket()
 		if err != nil {
-			log.Printf("Error reading pkt body from %v: %v",
-				fc.c.RemoteAddr(), err)
+			fc.logReadError(err)
 			return
 		}
+		fc.metrics.frameRead(pkt)
 
 		switch pkt.Cmd {
 		case FrameOpen:
-			fc.handleOpened(&pkt)
+			err = fc.handleOpened(pkt)
 		case FrameClose:
-			fc.handleClosed(&pkt)
+			fc.handleClosed(pkt)
 		case FrameData:
-			fc.handleData(&pkt)
+			fc.handleData(pkt)
 		default:
-			panic("unhandled msg")
+			err = protocolErrorf("unhandled command %v on channel %d",
+				pkt.Cmd, pkt.Channel)
+		}
+		if err != nil {
+			fc.log.Warn("error handling packet", pktAttrs(pkt), "err", err)
+			return
 		}
 	}
 }
 
+func (fc *frameClient) logReadError(err error) {
+	level := closeLevel(fc.closeMarker)
+	if err == io.EOF && level > slog.LevelInfo {
+		level = slog.LevelInfo
+	}
+	fc.log.Log(context.Background(), level, "error reading packet", "err", err)
+}
+
 func (fc *frameClient) writeRequests() {
+	enc := NewEncoder(countingWriter{fc.c, &fc.info.BytesWritten})
 	for {
 		var e *FramePacket
 		select {
@@ -148,16 +253,16 @@ func (fc *frameClient) writeRequests() {
 		case <-fc.closeMarker:
 			return
 		}
-		written, err := fc.c.Write(e.Bytes())
-		e.rch <- err
-		fc.info.BytesWritten += uint64(written)
-		// Clean up on close
-		if e.Cmd == FrameClose {
-			delete(fc.channels, e.Channel)
+		setWriteDeadline(fc.c, fc.writeTimeout)
+		err := enc.WritePacket(e)
+		if err == nil {
+			fc.metrics.frameWritten(e)
 		}
+		e.rch <- err
 		if err != nil {
-			log.Printf("write error: %v", err)
-			fc.c.Close()
+			fc.log.Log(context.Background(), closeLevel(fc.closeMarker),
+				"write error", pktAttrs(e), "err", err)
+			fc.closeWith(err)
 			return
 		}
 	}
@@ -165,67 +270,136 @@ func (fc *frameClient) writeRequests() {
 
 // NewClient converts a socket into a channel dialer.
 func NewClient(c net.Conn) ChannelDialer {
+	fc, _ := NewClientWithOptions(c, ClientOptions{})
+	return fc
+}
+
+// NewClientWithOptions converts a socket into a channel dialer
+// configured by the given options.
+func NewClientWithOptions(c net.Conn, opts ClientOptions) (ChannelDialer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	if err := setKeepAlive(c, opts.KeepAlive); err != nil {
+		return nil, err
+	}
+
 	fc := &frameClient{
-		c,
-		map[uint16]*clientChannel{},
-		make(chan *FramePacket, 16),
-		make(chan bool),
-		make(chan chan queueResult, 16),
-		Info{},
+		c:            c,
+		channels:     map[uint16]*clientChannel{},
+		quarantine:   map[uint16]bool{},
+		egress:       make(chan *FramePacket, opts.EgressQueue),
+		closeMarker:  make(chan bool),
+		connqueue:    make(chan chan queueResult, opts.OpenQueue),
+		maxWriteLen:  opts.MaxFrameLen,
+		writeTimeout: opts.WriteTimeout,
+		log:          sessionLogger(opts.Logger, c),
+		session:      SessionInfo{LocalAddr: c.LocalAddr(), RemoteAddr: c.RemoteAddr()},
+		hooks:        hookRunner{hooks: opts.Hooks},
+		metrics:      opts.Metrics,
+		protocols:    opts.Protocols,
+		offer:        offerProtocols(opts.Protocols),
 	}
+	fc.hooks.sessionEstablished(fc.session)
+	fc.metrics.sessionOpened()
+	registerSession(fc)
 
 	go fc.readResponses()
 	go fc.writeRequests()
 
-	return fc
+	return fc, nil
 }
 
 func (fc *frameClient) Close() error {
+	return fc.closeWith(nil)
+}
+
+// closeWith tears down the session because of the given cause (nil
+// for a local close).
+func (fc *frameClient) closeWith(cause error) error {
+	var err error
+	fc.closeOnce.Do(func() {
+		fc.cause = cause
+
+		fc.mu.Lock()
+		channels := make([]*clientChannel, 0, len(fc.channels))
+		for _, c := range fc.channels {
+			channels = append(channels, c)
+		}
+		fc.mu.Unlock()
+
+		for _, c := range channels {
+			c.terminateWith(ErrSessionClosed)
+		}
+
+		close(fc.closeMarker)
+		err = fc.c.Close()
+		fc.hooks.sessionClosed(fc.session, cause)
+		fc.metrics.sessionClosed(cause)
+		unregisterSession(fc)
+	})
+	return err
+}
+
+func (fc *frameClient) closed() bool {
 	select {
 	case <-fc.closeMarker:
-		return nil // already closed
+		return true
 	default:
 	}
+	return false
+}
 
-	for _, c := range fc.channels {
-		c.terminate()
+// closedErr is the error reported to callers on a closed session.
+// Protocol violations are reported as such, anything else as def.
+func (fc *frameClient) closedErr(def error) error {
+	if pe, ok := fc.cause.(*ProtocolError); ok {
+		return pe
 	}
-
-	close(fc.closeMarker)
-	return fc.c.Close()
+	return def
 }
 
 func (fc *frameClient) Dial() (net.Conn, error) {
-	pkt := &FramePacket{Cmd: FrameOpen, rch: make(chan error, 1)}
+	start := time.Now()
+	pkt := &FramePacket{Cmd: FrameOpen, Data: fc.offer, rch: make(chan error, 1)}
 
 	ch := make(chan queueResult)
 
 	select {
 	case fc.connqueue <- ch:
 	case <-fc.closeMarker:
-		return nil, errClosedConn
+		return nil, fc.closedErr(ErrSessionClosed)
 	}
 
 	select {
 	case fc.egress <- pkt:
 	case <-fc.closeMarker:
-		return nil, errClosedConn
+		return nil, fc.closedErr(ErrSessionClosed)
 	}
 
 	select {
 	case qr := <-ch:
+		fc.metrics.observeOpen(time.Since(start))
 		return qr.conn, qr.err
 	case <-fc.closeMarker:
-		return nil, io.EOF
+		return nil, fc.closedErr(ErrSessionClosed)
 	}
 }
 
 type clientChannel struct {
-	fc          *frameClient
-	channel     uint16
-	incoming    chan []byte
-	current     []byte
-	closeMarker chan bool
+	fc            *frameClient
+	channel       uint16
+	incoming      chan []byte
+	current       []byte
+	closeMarker   chan bool
+	closeOnce     sync.Once
+	readDeadline  deadline
+	writeDeadline deadline
+	reason        error // why the server reset the channel
+	closeAck      bool  // the server acknowledges closes
+	closedLocally atomic.Bool
+	channelStats
 }
 
 func (f *clientChannel) isClosed() bool {
@@ -237,27 +411,72 @@ func (f *clientChannel) isClosed() bool {
 	return false
 }
 
+// Read returns data delivered before the channel closed, then EOF or
+// the reason it was reset.
 func (f *clientChannel) Read(b []byte) (n int, err error) {
-	if f.isClosed() {
-		return 0, errClosedReadCh
+	if len(f.current) == 0 && f.isClosed() {
+		return 0, f.closedReadErr()
 	}
 	n, f.current, err = channelRead(b, f.current, f.incoming,
-		f.closeMarker, f.fc.closeMarker)
+		f.closeMarker, f.fc.closeMarker, f.readDeadline.wait())
+	f.countRead(n)
+	if err == io.EOF && f.isClosed() {
+		if n > 0 {
+			// Report the close on the next Read.
+			err = nil
+		} else {
+			err = resetOr(f.reason, err)
+		}
+	}
 	return n, err
 }
 
+// closedReadErr is what reading returns once the channel's closed
+// and its data's drained.
+func (f *clientChannel) closedReadErr() error {
+	if f.closedLocally.Load() {
+		return resetOr(f.reason, errClosedReadCh)
+	}
+	return resetOr(f.reason, io.EOF)
+}
+
 func (f *clientChannel) Write(b []byte) (n int, err error) {
-	return channelWrite(b, f.channel, f.fc.egress, f.closeMarker, f.fc.closeMarker)
+	atomic.AddInt64(&f.fc.pending, int64(len(b)))
+	defer atomic.AddInt64(&f.fc.pending, -int64(len(b)))
+	n, err = channelWrite(b, f.channel, f.fc.maxWriteLen, f.fc.egress,
+		f.closeMarker, f.fc.closeMarker, f.writeDeadline.wait())
+	f.countWritten(n)
+	if err == errClosedWriteCh {
+		err = resetOr(f.reason, err)
+	}
+	return n, err
 }
 
 func (f *clientChannel) Close() error {
+	f.closedLocally.Store(true)
 	defer f.terminate()
+
+	// Quarantine the ID until the server acknowledges the close, so
+	// late frames for it are never mistaken for a new channel's.
+	fc := f.fc
+	fc.mu.Lock()
+	active := fc.channels[f.channel] == f
+	if active {
+		delete(fc.channels, f.channel)
+		if f.closeAck {
+			fc.quarantine[f.channel] = true
+		}
+	}
+	fc.mu.Unlock()
+	if !active {
+		// Already closed, possibly by the server.
+		return nil
+	}
+
 	select {
-	case <-f.fc.closeMarker:
+	case <-fc.closeMarker:
 		// Socket's closed, we're done
-	case <-f.closeMarker:
-		// This channel is already closed.
-	case f.fc.egress <- &FramePacket{
+	case fc.egress <- &FramePacket{
 		Cmd:     FrameClose,
 		Channel: f.channel,
 		rch:     make(chan error, 1),
@@ -268,9 +487,19 @@ func (f *clientChannel) Close() error {
 }
 
 func (f *clientChannel) terminate() {
-	if !f.isClosed() {
+	f.terminateWith(nil)
+}
+
+// terminateWith closes the channel locally, recording the reason the
+// server reset it, if any.
+func (f *clientChannel) terminateWith(reason error) {
+	f.closeOnce.Do(func() {
+		f.reason = reason
 		close(f.closeMarker)
-	}
+		f.fc.hooks.channelClosed(f.info(f.fc.session, f.channel))
+		f.fc.metrics.channelClosed()
+		recordClosed(f.state(f.String(), f.channel))
+	})
 }
 
 type frameAddr struct {
@@ -295,15 +524,19 @@ func (f *clientChannel) RemoteAddr() net.Addr {
 }
 
 func (f *clientChannel) SetDeadline(t time.Time) error {
-	return errNotImpl
+	f.readDeadline.set(t)
+	f.writeDeadline.set(t)
+	return nil
 }
 
 func (f *clientChannel) SetReadDeadline(t time.Time) error {
-	return errNotImpl
+	f.readDeadline.set(t)
+	return nil
 }
 
 func (f *clientChannel) SetWriteDeadline(t time.Time) error {
-	return errNotImpl
+	f.writeDeadline.set(t)
+	return nil
 }
 
 func (f *clientChannel) String() string {
@@ -0,0 +1,248 @@
+// framesbench generates load against a frames server and reports
+// throughput and latency.
+//
+// Usage:
+//
+//	framesbench [-channels 16] [-size 1024] [-duration 10s]
+//	            [-framelen 32768] [-egress 16] [-backlog 0]
+//	framesbench -serve :8675 [-mode echo|sink]
+//	framesbench -addr server:8675 [-mode echo|sink]
+//
+// Without -addr, an in-process server is started on loopback.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+var (
+	serve    = flag.String("serve", "", "only run a server listening on this address")
+	addr     = flag.String("addr", "", "address of a remote server (default in-process)")
+	mode     = flag.String("mode", "echo", "echo (measure round trips) or sink (write only)")
+	channels = flag.Int("channels", 16, "number of concurrent channels")
+	size     = flag.Int("size", 1024, "message size in bytes")
+	duration = flag.Duration("duration", 10*time.Second, "how long to run")
+	reopen   = flag.Bool("reopen", false, "open a new channel for every message")
+	frameLen = flag.Int("framelen", 0, "largest frame sent by client and server (default 32768)")
+	egress   = flag.Int("egress", 0, "outgoing packets queued per session (default per frames)")
+	backlog  = flag.Int("backlog", 0, "opened channels queued awaiting the server's Accept")
+)
+
+func serverOptions() frames.ServerOptions {
+	return frames.ServerOptions{
+		MaxFrameLen:   *frameLen,
+		EgressQueue:   *egress,
+		AcceptBacklog: *backlog,
+	}
+}
+
+func clientOptions() frames.ClientOptions {
+	return frames.ClientOptions{
+		MaxFrameLen: *frameLen,
+		EgressQueue: *egress,
+	}
+}
+
+func serveChannel(c net.Conn, echo bool) {
+	defer c.Close()
+	if echo {
+		io.Copy(c, c)
+	} else {
+		io.Copy(io.Discard, c)
+	}
+}
+
+func listen(a string) net.Listener {
+	l, err := net.Listen("tcp", a)
+	if err != nil {
+		log.Fatalf("Error listening: %v", err)
+	}
+	ll, err := frames.ListenerListenerWithOptions(l, serverOptions())
+	if err != nil {
+		log.Fatalf("Error configuring server: %v", err)
+	}
+	return ll
+}
+
+func runServer(ll net.Listener, echo bool) error {
+	for {
+		c, err := ll.Accept()
+		if err != nil {
+			return err
+		}
+		go serveChannel(c, echo)
+	}
+}
+
+type result struct {
+	msgs   int64
+	bytes  int64
+	opens  []time.Duration
+	rtts   []time.Duration
+	errors int64
+}
+
+func (r *result) merge(o *result) {
+	r.msgs += o.msgs
+	r.bytes += o.bytes
+	r.opens = append(r.opens, o.opens...)
+	r.rtts = append(r.rtts, o.rtts...)
+	r.errors += o.errors
+}
+
+func worker(d frames.ChannelDialer, echo bool, stop *int32) *result {
+	r := &result{}
+	msg := make([]byte, *size)
+	buf := make([]byte, *size)
+
+	var c net.Conn
+	dial := func() bool {
+		start := time.Now()
+		var err error
+		c, err = d.Dial()
+		if err != nil {
+			log.Printf("Error opening channel: %v", err)
+			r.errors++
+			return false
+		}
+		r.opens = append(r.opens, time.Since(start))
+		return true
+	}
+
+	if !dial() {
+		return r
+	}
+	for atomic.LoadInt32(stop) == 0 {
+		start := time.Now()
+		if _, err := c.Write(msg); err != nil {
+			log.Printf("Error writing: %v", err)
+			r.errors++
+			break
+		}
+		if echo {
+			if _, err := io.ReadFull(c, buf); err != nil {
+				log.Printf("Error reading: %v", err)
+				r.errors++
+				break
+			}
+			r.rtts = append(r.rtts, time.Since(start))
+		}
+		r.msgs++
+		r.bytes += int64(len(msg))
+
+		if *reopen {
+			c.Close()
+			if !dial() {
+				return r
+			}
+		}
+	}
+	c.Close()
+	return r
+}
+
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	i := int(float64(len(ds)) * p)
+	if i >= len(ds) {
+		i = len(ds) - 1
+	}
+	return ds[i]
+}
+
+func report(name string, ds []time.Duration) {
+	if len(ds) == 0 {
+		return
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var total time.Duration
+	for _, d := range ds {
+		total += d
+	}
+	fmt.Printf("%-10s n=%-9d mean=%-10v p50=%-10v p99=%-10v p999=%-10v max=%v\n",
+		name, len(ds), total/time.Duration(len(ds)),
+		percentile(ds, .5), percentile(ds, .99), percentile(ds, .999), ds[len(ds)-1])
+}
+
+func main() {
+	flag.Parse()
+
+	echo := true
+	switch *mode {
+	case "echo":
+	case "sink":
+		echo = false
+	default:
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+
+	if *serve != "" {
+		l := listen(*serve)
+		log.Printf("Serving %v on %v", *mode, l.Addr())
+		log.Fatal(runServer(l, echo))
+	}
+
+	target := *addr
+	if target == "" {
+		l := listen("127.0.0.1:0")
+		go runServer(l, echo)
+		target = l.Addr().String()
+	}
+
+	c, err := net.Dial("tcp", target)
+	if err != nil {
+		log.Fatalf("Error connecting to %v: %v", target, err)
+	}
+	d, err := frames.NewClientWithOptions(c, clientOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	fmt.Printf("%v: %d channels, %d byte messages, %v against %v\n",
+		*mode, *channels, *size, *duration, target)
+
+	var stop int32
+	var wg sync.WaitGroup
+	results := make([]*result, *channels)
+	start := time.Now()
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = worker(d, echo, &stop)
+		}(i)
+	}
+	time.Sleep(*duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := &result{}
+	for _, r := range results {
+		total.merge(r)
+	}
+
+	fmt.Printf("messages   %d (%.0f/s)\n", total.msgs, float64(total.msgs)/elapsed.Seconds())
+	fmt.Printf("throughput %.2f MB/s\n", float64(total.bytes)/elapsed.Seconds()/1e6)
+	report("open", total.opens)
+	report("roundtrip", total.rtts)
+	fmt.Printf("info       %v\n", d.GetInfo())
+	if total.errors > 0 {
+		fmt.Printf("errors     %d\n", total.errors)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,214 @@
+// framesdump decodes frames captures and live frames traffic.
+//
+// Usage:
+//
+//	framesdump [-channels 1,2] [-session 3] capture.frm
+//	framesdump -listen :8676 -target backend:8675 [-w capture.frm]
+//	framesdump -replay capture.frm [-session 3] -target backend:8675
+//
+// Each proxied connection is recorded as its own session, shown as
+// #n in the output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+var (
+	channels = flag.String("channels", "", "comma separated channels to show (default all)")
+	session  = flag.Uint("session", 0, "only show, or replay, this session (default all, or the first for -replay)")
+	listen   = flag.String("listen", "", "proxy frames connections accepted here to -target, decoding live")
+	target   = flag.String("target", "", "frames server to proxy or replay to")
+	capture  = flag.String("w", "", "also write proxied traffic to this capture file")
+	replay   = flag.String("replay", "", "replay the client side of this capture against -target")
+	timing   = flag.Bool("timing", false, "reproduce captured delays when replaying")
+)
+
+type channelFilter map[uint16]bool
+
+func parseChannels(s string) (channelFilter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	rv := channelFilter{}
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %q: %v", f, err)
+		}
+		rv[uint16(n)] = true
+	}
+	return rv, nil
+}
+
+func (f channelFilter) show(r frames.CaptureRecord) bool {
+	if *session != 0 && r.Session != uint32(*session) {
+		return false
+	}
+	return f == nil || f[r.Packet.Channel]
+}
+
+func dump(fn string, filter channelFilter) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cr, err := frames.NewCaptureReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		r, err := cr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filter.show(r) {
+			fmt.Println(r)
+		}
+	}
+}
+
+func proxy(filter channelFilter) error {
+	var out io.Writer = io.Discard
+	if *capture != "" {
+		f, err := os.Create(*capture)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	// Everything captured is written to the capture file and
+	// decoded for printing.
+	pr, pw := io.Pipe()
+	cw, err := frames.NewCaptureWriter(io.MultiWriter(out, pw))
+	if err != nil {
+		return err
+	}
+	go func() {
+		cr, err := frames.NewCaptureReader(pr)
+		if err != nil {
+			log.Fatalf("Error reading live capture: %v", err)
+		}
+		for {
+			r, err := cr.ReadRecord()
+			if err != nil {
+				log.Fatalf("Error reading live capture: %v", err)
+			}
+			if filter.show(r) {
+				fmt.Println(r)
+			}
+		}
+	}()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("Proxying %v -> %v", l.Addr(), *target)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			s, err := net.Dial("tcp", *target)
+			if err != nil {
+				log.Printf("Error connecting to %v: %v", *target, err)
+				return
+			}
+			rc := frames.NewRecordingConn(s, cw)
+			defer rc.Close()
+			log.Printf("Session #%d %v -> %v", rc.Session(), c.RemoteAddr(), s.RemoteAddr())
+			go io.Copy(rc, c)
+			io.Copy(c, rc)
+		}()
+	}
+}
+
+func doReplay() error {
+	f, err := os.Open(*replay)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr, err := frames.NewCaptureReader(f)
+	if err != nil {
+		return err
+	}
+
+	c, err := net.DialTimeout("tcp", *target, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	report, err := frames.Replay(cr, c, frames.ReplayOptions{
+		Timing:  *timing,
+		Session: uint32(*session),
+	})
+	if report != nil {
+		fmt.Printf("Sent %v packets, received %v\n", report.Sent, report.Received)
+		for _, m := range report.Mismatches {
+			fmt.Println("MISMATCH:", m)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Mismatches) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	filter, err := parseChannels(*channels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *replay != "":
+		if *target == "" {
+			log.Fatalf("-replay requires -target")
+		}
+		err = doReplay()
+	case *listen != "":
+		if *target == "" {
+			log.Fatalf("-listen requires -target")
+		}
+		err = proxy(filter)
+	case flag.NArg() > 0:
+		for _, fn := range flag.Args() {
+			if err = dump(fn, filter); err != nil {
+				break
+			}
+		}
+	default:
+		flag.Usage()
+		os.Exit(64)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
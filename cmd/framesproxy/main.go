@@ -0,0 +1,74 @@
+// framesproxy is an HTTP reverse proxy forwarding requests to
+// framesweb servers, each over one multiplexed frames connection.
+//
+// Usage:
+//
+//	framesproxy [-listen :8080] -route /=backend:8675 [-route example.com/api/=api:8675]
+//
+// Routes are patterns as for framesweb.NewReverseProxy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dustin/frames"
+	"github.com/dustin/frames/http"
+)
+
+type routes map[string]string
+
+func (r routes) String() string {
+	var parts []string
+	for p, a := range r {
+		parts = append(parts, p+"="+a)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r routes) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 1 || i == len(s)-1 {
+		return fmt.Errorf("invalid route %q, want pattern=addr", s)
+	}
+	r[s[:i]] = s[i+1:]
+	return nil
+}
+
+var (
+	listen = flag.String("listen", ":8080", "HTTP address to listen on")
+	route  = routes{}
+)
+
+func init() {
+	flag.Var(route, "route", "pattern=addr routing requests to a frames server (repeatable)")
+}
+
+func main() {
+	flag.Parse()
+	if len(route) == 0 {
+		flag.Usage()
+		os.Exit(64)
+	}
+
+	dialers := map[string]frames.ChannelDialer{}
+	for pattern, addr := range route {
+		var d net.Dialer
+		cd, err := frames.NewReconnectingClient(func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}, frames.ReconnectOptions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		dialers[pattern] = cd
+	}
+
+	log.Printf("Listening on %v", *listen)
+	log.Fatal(http.ListenAndServe(*listen, framesweb.NewReverseProxy(dialers)))
+}
@@ -0,0 +1,97 @@
+package frames
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrPacketTooLarge is returned when encoding a packet whose data
+// exceeds the protocol's maximum data length.
+var ErrPacketTooLarge = errors.New("packet data too large")
+
+// A Decoder reads packets from a stream.
+type Decoder struct {
+	r   io.Reader
+	hdr [minPktLen]byte
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// ReadPacket reads the next packet from the stream.
+//
+// io.EOF is returned if the stream ends cleanly between packets and
+// io.ErrUnexpectedEOF if it ends within one.  An invalid header is
+// reported as a *ProtocolError.
+func (d *Decoder) ReadPacket() (*FramePacket, error) {
+	if _, err := io.ReadFull(d.r, d.hdr[:]); err != nil {
+		return nil, err
+	}
+	pkt, err := DecodeHeader(d.hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(d.r, pkt.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &pkt, nil
+}
+
+// An Encoder writes packets to a stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// WritePacket writes a packet to the stream in a single Write.
+func (e *Encoder) WritePacket(pkt *FramePacket) error {
+	if len(pkt.Data) > maxWriteLen {
+		return ErrPacketTooLarge
+	}
+	_, err := e.w.Write(pkt.Bytes())
+	return err
+}
+
+// ReadPacket reads a single packet from r.
+func ReadPacket(r io.Reader) (*FramePacket, error) {
+	return NewDecoder(r).ReadPacket()
+}
+
+// WritePacket writes a single packet to w.
+func WritePacket(w io.Writer, pkt *FramePacket) error {
+	return NewEncoder(w).WritePacket(pkt)
+}
+
+// countingReader counts bytes read through it into n.
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+// countingWriter counts bytes written through it into n.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
@@ -0,0 +1,96 @@
+package frames
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	t.Parallel()
+	pkts := []*FramePacket{
+		{Cmd: FrameOpen, Data: []byte{}},
+		{Cmd: FrameOpen, Status: FrameError, Channel: 13, Data: []byte("no")},
+		{Cmd: FrameClose, Status: FrameStatus(7), Channel: 0xffff, Data: []byte{}},
+		{Cmd: FrameData, Channel: 11, Data: make([]byte, maxWriteLen)},
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	for _, p := range pkts {
+		if err := enc.WritePacket(p); err != nil {
+			t.Fatalf("Error encoding %v: %v", p, err)
+		}
+	}
+
+	dec := NewDecoder(&b)
+	for _, exp := range pkts {
+		got, err := dec.ReadPacket()
+		if err != nil {
+			t.Fatalf("Error decoding %v: %v", exp, err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("Expected %v, got %v", exp, got)
+		}
+	}
+	if _, err := dec.ReadPacket(); err != io.EOF {
+		t.Errorf("Expected EOF at end of stream, got %v", err)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	t.Parallel()
+	err := WritePacket(io.Discard, &FramePacket{Data: make([]byte, maxWriteLen+1)})
+	if err != ErrPacketTooLarge {
+		t.Errorf("Expected ErrPacketTooLarge, got %v", err)
+	}
+
+	_, err = ReadPacket(bytes.NewReader([]byte{0, 4, 0, 1, 2, 0, 'h'}))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected unexpected EOF on short body, got %v", err)
+	}
+
+	_, err = ReadPacket(bytes.NewReader([]byte{0, 4, 0}))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected unexpected EOF on short header, got %v", err)
+	}
+}
+
+func TestDialErrorStatus(t *testing.T) {
+	t.Parallel()
+	c, peer := net.Pipe()
+	defer peer.Close()
+
+	fc := NewClient(c)
+	defer fc.Close()
+
+	go func() {
+		if _, err := ReadPacket(peer); err != nil {
+			return
+		}
+		WritePacket(peer, &FramePacket{
+			Cmd:    FrameOpen,
+			Status: FrameError,
+			Data:   []byte("channels exhausted"),
+		})
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fc.Dial()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		want := "status=Error, data=channels exhausted"
+		if err == nil || err.Error() != want {
+			t.Errorf("Expected %q, got %v", want, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Dial never returned")
+	}
+}
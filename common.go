@@ -1,9 +1,18 @@
 package frames
 
-import "io"
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
 
 func channelRead(b []byte, current []byte, incoming chan []byte,
-	close1, close2 chan bool) (int, []byte, error) {
+	close1, close2, expired chan bool) (int, []byte, error) {
+
+	if isClosedChan(expired) {
+		return 0, current, os.ErrDeadlineExceeded
+	}
 
 	read := 0
 	for len(b) > 0 {
@@ -14,6 +23,8 @@ func channelRead(b []byte, current []byte, incoming chan []byte,
 				case current, ok = <-incoming:
 				case <-close1:
 				case <-close2:
+				case <-expired:
+					return 0, current, os.ErrDeadlineExceeded
 				}
 			} else {
 				select {
@@ -36,15 +47,22 @@ func channelRead(b []byte, current []byte, incoming chan []byte,
 	return read, current, nil
 }
 
-func channelWrite(b []byte, channel uint16, egress chan *FramePacket,
-	close1, close2 chan bool) (int, error) {
+func channelWrite(b []byte, channel uint16, maxLen int,
+	egress chan *FramePacket, close1, close2, expired chan bool) (int, error) {
+
+	if isClosedChan(close1) {
+		return 0, errClosedWriteCh
+	}
+	if isClosedChan(expired) {
+		return 0, os.ErrDeadlineExceeded
+	}
 
 	written := 0
 	for len(b) > 0 {
 		todo := b
 
-		if len(todo) > maxWriteLen {
-			todo = b[0:maxWriteLen]
+		if len(todo) > maxLen {
+			todo = b[0:maxLen]
 		}
 		b = b[len(todo):]
 
@@ -62,7 +80,9 @@ func channelWrite(b []byte, channel uint16, egress chan *FramePacket,
 		case <-close1:
 			return written, errClosedWriteCh
 		case <-close2:
-			return written, errClosedConn
+			return written, ErrSessionClosed
+		case <-expired:
+			return written, os.ErrDeadlineExceeded
 		}
 
 		// Flush it
@@ -76,8 +96,76 @@ func channelWrite(b []byte, channel uint16, egress chan *FramePacket,
 		case <-close1:
 			return written, errClosedWriteCh
 		case <-close2:
-			return written, errClosedConn
+			return written, ErrSessionClosed
+		case <-expired:
+			// It'll still be sent.
+			return written, os.ErrDeadlineExceeded
 		}
 	}
 	return written, nil
 }
+
+// channelStats tracks the lifetime and traffic of a channel.
+type channelStats struct {
+	opened         time.Time
+	lastActive     int64 // unix nanos
+	bytesRead      uint64
+	bytesWritten   uint64
+	bytesDelivered uint64
+	protocol       string // negotiated at open
+}
+
+func newChannelStats() channelStats {
+	now := time.Now()
+	return channelStats{opened: now, lastActive: now.UnixNano()}
+}
+
+func (c *channelStats) info(s SessionInfo, channel uint16) ChannelInfo {
+	return ChannelInfo{
+		Session:      s,
+		Channel:      channel,
+		Opened:       c.opened,
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		Protocol:     c.protocol,
+	}
+}
+
+func (c *channelStats) state(name string, channel uint16) ChannelState {
+	read := atomic.LoadUint64(&c.bytesRead)
+	return ChannelState{
+		Channel:      channel,
+		Name:         name,
+		Opened:       c.opened,
+		LastActive:   time.Unix(0, atomic.LoadInt64(&c.lastActive)),
+		BytesRead:    read,
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		Queued:       atomic.LoadUint64(&c.bytesDelivered) - read,
+	}
+}
+
+// queued is the number of bytes delivered but not yet read.
+func (c *channelStats) queued() int {
+	return int(atomic.LoadUint64(&c.bytesDelivered) - atomic.LoadUint64(&c.bytesRead))
+}
+
+func (c *channelStats) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *channelStats) countRead(n int) {
+	atomic.AddUint64(&c.bytesRead, uint64(n))
+	c.touch()
+}
+
+func (c *channelStats) countWritten(n int) {
+	atomic.AddUint64(&c.bytesWritten, uint64(n))
+	c.touch()
+}
+
+// countDelivered counts data handed from the session to the channel,
+// but not necessarily read by the application yet.
+func (c *channelStats) countDelivered(n int) {
+	atomic.AddUint64(&c.bytesDelivered, uint64(n))
+	c.touch()
+}
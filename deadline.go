@@ -0,0 +1,58 @@
+package frames
+
+import (
+	"sync"
+	"time"
+)
+
+// A deadline is a channel's read or write deadline.  The zero value
+// has none.
+type deadline struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	done  chan bool // closed when the deadline passes
+}
+
+// set changes the deadline to t.  A zero t means none.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// A passed deadline's done is closed, or about to be by its
+	// timer, so it needs a fresh one.
+	if d.timer != nil && !d.timer.Stop() {
+		d.done = nil
+	}
+	d.timer = nil
+	if d.done == nil || isClosedChan(d.done) {
+		d.done = make(chan bool)
+	}
+	if t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.done)
+		return
+	}
+	done := d.done
+	d.timer = time.AfterFunc(dur, func() { close(done) })
+}
+
+// wait returns a channel that's closed once the deadline passes.
+func (d *deadline) wait() chan bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done == nil {
+		d.done = make(chan bool)
+	}
+	return d.done
+}
+
+func isClosedChan(c chan bool) bool {
+	select {
+	case <-c:
+		return true
+	default:
+	}
+	return false
+}
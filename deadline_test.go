@@ -0,0 +1,65 @@
+package frames
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChannelDeadlines(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	c, s := net.Pipe()
+	l, err := ListenWithOptions(s, ServerOptions{Logger: discardLogger})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	d, err := NewClientWithOptions(c, ClientOptions{Logger: discardLogger})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer d.Close()
+
+	cc, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+
+	for _, ch := range []net.Conn{cc, sc} {
+		if err := ch.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+			t.Fatalf("Error setting deadline on %v: %v", ch, err)
+		}
+		var ne net.Error
+		_, err := ch.Read(make([]byte, 1))
+		if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("Expected deadline exceeded on %v, got %v", ch, err)
+		}
+
+		// Clearing it lets reads wait again.
+		ch.SetReadDeadline(time.Time{})
+		peer := sc
+		if ch == sc {
+			peer = cc
+		}
+		go peer.Write([]byte("x"))
+		if _, err := ch.Read(make([]byte, 1)); err != nil {
+			t.Errorf("Error reading on %v: %v", ch, err)
+		}
+	}
+
+	// A past deadline fails writes immediately.
+	sc.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := sc.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded writing, got %v", err)
+	}
+}
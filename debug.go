@@ -0,0 +1,133 @@
+package frames
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of closed channels remembered for RecentlyClosed.
+const recentlyClosedLen = 100
+
+// ChannelState describes a live or recently closed channel.
+type ChannelState struct {
+	Channel uint16 `json:"channel"`
+	// Name is the channel's String() form.
+	Name       string    `json:"name"`
+	Opened     time.Time `json:"opened"`
+	LastActive time.Time `json:"last_active"`
+	// Closed is zero for a live channel.
+	Closed       time.Time `json:"closed"`
+	BytesRead    uint64    `json:"read"`
+	BytesWritten uint64    `json:"written"`
+	// Queued is the number of bytes received for the channel,
+	// but not yet read by the application.
+	Queued uint64 `json:"queued"`
+}
+
+// SessionState describes a live session and its channels.
+type SessionState struct {
+	LocalAddr  string         `json:"local"`
+	RemoteAddr string         `json:"remote"`
+	Server     bool           `json:"server"`
+	Info       Info           `json:"info"`
+	Channels   []ChannelState `json:"channels"`
+}
+
+type liveSession interface {
+	state() SessionState
+}
+
+var registry = struct {
+	sync.Mutex
+	sessions map[liveSession]bool
+	// Traffic from sessions that have gone away.
+	retired Info
+}{sessions: map[liveSession]bool{}}
+
+// Recently closed channels are kept in a lock-free ring so closing a
+// channel doesn't contend with every other session in the process.
+var recentlyClosed struct {
+	ring [recentlyClosedLen]atomic.Pointer[ChannelState]
+	seq  atomic.Uint64
+}
+
+func registerSession(s liveSession) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.sessions[s] = true
+}
+
+func unregisterSession(s liveSession) {
+	registry.Lock()
+	defer registry.Unlock()
+	st := s.state()
+	delete(registry.sessions, s)
+	registry.retired.BytesRead += st.Info.BytesRead
+	registry.retired.BytesWritten += st.Info.BytesWritten
+}
+
+func recordClosed(c ChannelState) {
+	c.Closed = time.Now()
+	n := recentlyClosed.seq.Add(1) - 1
+	recentlyClosed.ring[n%recentlyClosedLen].Store(&c)
+}
+
+func liveSessions() []liveSession {
+	registry.Lock()
+	defer registry.Unlock()
+	rv := make([]liveSession, 0, len(registry.sessions))
+	for s := range registry.sessions {
+		rv = append(rv, s)
+	}
+	return rv
+}
+
+// Sessions returns the state of every live session in the process,
+// ordered by local then remote address.
+func Sessions() []SessionState {
+	var rv []SessionState
+	for _, s := range liveSessions() {
+		rv = append(rv, s.state())
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].LocalAddr != rv[j].LocalAddr {
+			return rv[i].LocalAddr < rv[j].LocalAddr
+		}
+		return rv[i].RemoteAddr < rv[j].RemoteAddr
+	})
+	return rv
+}
+
+// RecentlyClosed returns the most recently closed channels in the
+// process, newest first.
+func RecentlyClosed() []ChannelState {
+	n := recentlyClosed.seq.Load()
+	rv := make([]ChannelState, 0, min(n, recentlyClosedLen))
+	for i := uint64(1); i <= n && i <= recentlyClosedLen; i++ {
+		if c := recentlyClosed.ring[(n-i)%recentlyClosedLen].Load(); c != nil {
+			rv = append(rv, *c)
+		}
+	}
+	return rv
+}
+
+// TotalInfo returns the traffic of every session in the process,
+// live or closed, and the number of channels currently open.
+func TotalInfo() Info {
+	registry.Lock()
+	defer registry.Unlock()
+	rv := registry.retired
+	for s := range registry.sessions {
+		i := s.state().Info
+		rv.BytesRead += i.BytesRead
+		rv.BytesWritten += i.BytesWritten
+		rv.ChannelsOpen += i.ChannelsOpen
+	}
+	return rv
+}
+
+func sortChannels(chs []ChannelState) {
+	sort.Slice(chs, func(i, j int) bool { return chs[i].Channel < chs[j].Channel })
+}
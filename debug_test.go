@@ -0,0 +1,96 @@
+package frames
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findSession(local string) (SessionState, bool) {
+	for _, s := range Sessions() {
+		if s.LocalAddr == local && !s.Server {
+			return s, true
+		}
+	}
+	return SessionState{}, false
+}
+
+func TestSessionIntrospection(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc := runTestEchoServer(t)
+	defer tc.l.Close()
+
+	c, err := net.Dial("tcp", tc.addr)
+	if err != nil {
+		t.Fatalf("Error connecting to my server: %v", err)
+	}
+	fc := NewClient(c)
+	local := c.LocalAddr().String()
+
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	ch.Write([]byte("hello"))
+	if _, err := io.ReadFull(ch, make([]byte, 2)); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+
+	s, ok := findSession(local)
+	if !ok {
+		t.Fatalf("Couldn't find session for %v in %+v", local, Sessions())
+	}
+	if len(s.Channels) != 1 {
+		t.Fatalf("Expected one channel, got %+v", s.Channels)
+	}
+	cs := s.Channels[0]
+	if cs.BytesRead != 2 || cs.BytesWritten != 5 || cs.Queued != 3 {
+		t.Errorf("Unexpected channel state: %+v", cs)
+	}
+	if cs.Name != ch.(*clientChannel).String() {
+		t.Errorf("Expected name %v, got %v", ch, cs.Name)
+	}
+
+	ch.Close()
+	found := false
+	for _, rc := range RecentlyClosed() {
+		if rc.Name == strings.TrimSuffix(cs.Name, "}")+" CLOSED}" {
+			found = true
+			if rc.Closed.IsZero() || rc.BytesWritten != 5 {
+				t.Errorf("Unexpected closed channel state: %+v", rc)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Didn't find %v in recently closed", cs.Name)
+	}
+
+	before := TotalInfo()
+	if before.BytesWritten == 0 {
+		t.Errorf("Expected some bytes written in %v", before)
+	}
+	fc.Close()
+	if _, ok := findSession(local); ok {
+		t.Errorf("Session still listed after close")
+	}
+	if after := TotalInfo(); after.BytesWritten < before.BytesWritten {
+		t.Errorf("Total bytes written went backwards: %v -> %v", before, after)
+	}
+}
+
+func TestRecentlyClosedRing(t *testing.T) {
+	for i := 0; i < recentlyClosedLen+5; i++ {
+		recordClosed(ChannelState{Channel: uint16(i)})
+	}
+	rc := RecentlyClosed()
+	if len(rc) != recentlyClosedLen {
+		t.Fatalf("Expected %v closed channels, got %v", recentlyClosedLen, len(rc))
+	}
+	if rc[0].Channel != recentlyClosedLen+4 {
+		t.Errorf("Expected newest first, got %+v", rc[0])
+	}
+}
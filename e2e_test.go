@@ -73,6 +73,10 @@ func runTestServer(t *testing.T) *testService {
 
 func runTestEchoServer(t *testing.T) *testService {
 	t.Parallel()
+	return startTestEchoServer(t)
+}
+
+func startTestEchoServer(t *testing.T) *testService {
 	ta, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("Error resolving test server addr: %v", err)
@@ -293,3 +297,96 @@ func TestEndToEndLargeWrites(t *testing.T) {
 		t.Errorf("Expected no error closing, got %v", err)
 	}
 }
+
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (f failingListener) Accept() (net.Conn, error) {
+	return nil, f.err
+}
+
+func TestListenerListenerAcceptError(t *testing.T) {
+	t.Parallel()
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Errorf("accept broke")
+	l, err := ListenerListener(failingListener{tl, want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, err := l.Accept(); err != want {
+		t.Fatalf("Expected %v, got %v", want, err)
+	}
+	if _, err := l.Accept(); err != want {
+		t.Fatalf("Expected %v again, got %v", want, err)
+	}
+}
+
+// Data delivered before the peer closes a channel is still read.
+func TestReadAfterPeerClose(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	l, err := ListenWithOptions(s, ServerOptions{Logger: discardLogger})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		ch, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ch.Write([]byte("hello, world"))
+		ch.Close()
+	}()
+
+	closed := make(chan bool)
+	fc, err := NewClientWithOptions(c, ClientOptions{
+		Logger: discardLogger,
+		Hooks:  Hooks{ChannelClosed: func(ChannelInfo) { close(closed) }},
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer fc.Close()
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(ch, b); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	<-closed
+
+	got := string(b)
+	for {
+		n, err := ch.Read(b)
+		got += string(b[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading after close with %q: %v", got, err)
+		}
+	}
+	if got != "hello, world" {
+		t.Errorf("Expected hello, world, got %q", got)
+	}
+	if _, err := ch.Read(b); err != io.EOF {
+		t.Errorf("Expected EOF reading again, got %v", err)
+	}
+	ch.Close()
+	if _, err := ch.Read(b); err != errClosedReadCh {
+		t.Errorf("Expected closed channel error after Close, got %v", err)
+	}
+}
@@ -0,0 +1,480 @@
+package frames
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EndpointState describes an endpoint to a Policy.
+type EndpointState struct {
+	Addr         string
+	ChannelsOpen int
+	// Failures is the number of consecutive failed opens and
+	// health checks.
+	Failures int
+}
+
+// A Policy chooses the order in which healthy endpoints are tried
+// when opening a channel.
+type Policy interface {
+	// Order returns eps, which are in configured order, in the
+	// order they should be tried.
+	Order(eps []EndpointState) []EndpointState
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+// RoundRobin returns a Policy that rotates through the endpoints.
+func RoundRobin() Policy {
+	return &roundRobin{}
+}
+
+func (p *roundRobin) Order(eps []EndpointState) []EndpointState {
+	if len(eps) == 0 {
+		return eps
+	}
+	n := int((atomic.AddUint64(&p.next, 1) - 1) % uint64(len(eps)))
+	return append(eps[n:len(eps):len(eps)], eps[:n]...)
+}
+
+type leastChannels struct{}
+
+// LeastChannels returns a Policy preferring the endpoint with the
+// fewest open channels.
+func LeastChannels() Policy {
+	return leastChannels{}
+}
+
+func (leastChannels) Order(eps []EndpointState) []EndpointState {
+	rv := append([]EndpointState(nil), eps...)
+	for i := 1; i < len(rv); i++ {
+		for j := i; j > 0 && rv[j].ChannelsOpen < rv[j-1].ChannelsOpen; j-- {
+			rv[j], rv[j-1] = rv[j-1], rv[j]
+		}
+	}
+	return rv
+}
+
+type primaryBackup struct{}
+
+// PrimaryBackup returns a Policy that always prefers endpoints in the
+// order they're configured, using later ones only while earlier ones
+// are unhealthy.
+func PrimaryBackup() Policy {
+	return primaryBackup{}
+}
+
+func (primaryBackup) Order(eps []EndpointState) []EndpointState {
+	return eps
+}
+
+// FailoverOptions configure a client created with NewFailoverClient.
+type FailoverOptions struct {
+	// Addrs are the endpoints to connect to.
+	Addrs []string
+	// Resolve, if not nil, is called before every health check
+	// round to find the current endpoints, instead of using Addrs.
+	Resolve func(ctx context.Context) ([]string, error)
+	// Dial connects to an endpoint.  Default is a TCP net.Dialer.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+	// Policy orders healthy endpoints for Dial.  Default is
+	// RoundRobin.
+	Policy Policy
+	// Client configures each session.
+	Client ClientOptions
+	// CheckInterval is the time between health checks, which also
+	// reconnect dead sessions.  Default is 5s.
+	CheckInterval time.Duration
+	// Probe checks a session's health.  The default opens and
+	// closes a channel.
+	Probe func(d ChannelDialer) error
+	// CheckTimeout bounds connecting to and probing each endpoint
+	// during a health check.  An endpoint that doesn't finish in
+	// time fails the check, and its session is replaced.  Default
+	// is CheckInterval.
+	CheckTimeout time.Duration
+	// MaxFailures is the number of consecutive failures after which
+	// an endpoint is considered unhealthy.  A dead session is
+	// unhealthy immediately.  Default is 3.
+	MaxFailures int
+}
+
+func (o FailoverOptions) validate() error {
+	if len(o.Addrs) == 0 && o.Resolve == nil {
+		return invalidOption("Addrs", o.Addrs)
+	}
+	if o.CheckInterval < 0 {
+		return invalidOption("CheckInterval", o.CheckInterval)
+	}
+	if o.CheckTimeout < 0 {
+		return invalidOption("CheckTimeout", o.CheckTimeout)
+	}
+	if o.MaxFailures < 0 {
+		return invalidOption("MaxFailures", o.MaxFailures)
+	}
+	return o.Client.validate()
+}
+
+func (o FailoverOptions) withDefaults() FailoverOptions {
+	if o.Dial == nil {
+		var d net.Dialer
+		o.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if o.Policy == nil {
+		o.Policy = RoundRobin()
+	}
+	if o.CheckInterval == 0 {
+		o.CheckInterval = 5 * time.Second
+	}
+	if o.CheckTimeout == 0 {
+		o.CheckTimeout = o.CheckInterval
+	}
+	if o.Probe == nil {
+		o.Probe = probeChannel
+	}
+	if o.MaxFailures == 0 {
+		o.MaxFailures = 3
+	}
+	return o
+}
+
+func probeChannel(d ChannelDialer) error {
+	c, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+type endpoint struct {
+	addr     string
+	fc       *frameClient
+	healthy  bool
+	failures int
+}
+
+type failoverClient struct {
+	opts        FailoverOptions
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	endpoints   []*endpoint
+	retired     Info
+	closeMarker chan bool
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewFailoverClient returns a ChannelDialer over sessions to several
+// endpoints.  Endpoints are health checked periodically, and Dial
+// opens channels on healthy ones in the order chosen by the Policy,
+// moving on to the next when one fails.
+//
+// An error is returned if no endpoint is healthy initially.
+func NewFailoverClient(opts FailoverOptions) (ChannelDialer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &failoverClient{
+		opts:        opts,
+		ctx:         ctx,
+		cancel:      cancel,
+		closeMarker: make(chan bool),
+	}
+
+	if err := f.checkAll(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !f.anyUsable() {
+		f.Close()
+		return nil, ErrNoSessions
+	}
+
+	f.wg.Add(1)
+	go f.checker()
+	return f, nil
+}
+
+func (f *failoverClient) connect(ctx context.Context, addr string) (*frameClient, error) {
+	c, err := f.opts.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewClientWithOptions(c, f.opts.Client)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return d.(*frameClient), nil
+}
+
+// retire closes ep's session, if it's still fc, accumulating its
+// counters.
+func (f *failoverClient) retire(ep *endpoint, fc *frameClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ep.fc != fc || fc == nil {
+		return
+	}
+	ep.fc = nil
+	ep.healthy = false
+	fc.Close()
+	i := fc.GetInfo()
+	f.retired.BytesRead += i.BytesRead
+	f.retired.BytesWritten += i.BytesWritten
+}
+
+// resolve updates the endpoint list, closing sessions to endpoints
+// that went away.
+func (f *failoverClient) resolve() error {
+	addrs := f.opts.Addrs
+	if f.opts.Resolve != nil {
+		var err error
+		addrs, err = f.opts.Resolve(f.ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	old := map[string]*endpoint{}
+	for _, ep := range f.endpoints {
+		old[ep.addr] = ep
+	}
+	var eps []*endpoint
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		ep, ok := old[a]
+		if !ok {
+			ep = &endpoint{addr: a}
+		}
+		delete(old, a)
+		eps = append(eps, ep)
+	}
+	f.endpoints = eps
+	f.mu.Unlock()
+
+	for _, ep := range old {
+		f.retire(ep, ep.fc)
+	}
+	return nil
+}
+
+func (f *failoverClient) check(ep *endpoint) {
+	ctx, cancel := context.WithTimeout(f.ctx, f.opts.CheckTimeout)
+	defer cancel()
+
+	f.mu.Lock()
+	fc := ep.fc
+	f.mu.Unlock()
+
+	var err error
+	if fc == nil || fc.closed() {
+		f.retire(ep, fc)
+		fc, err = f.connect(ctx, ep.addr)
+		if err == nil {
+			f.mu.Lock()
+			if f.closed() {
+				f.mu.Unlock()
+				fc.Close()
+				return
+			}
+			ep.fc = fc
+			f.mu.Unlock()
+		}
+	}
+	if err == nil {
+		err = f.probe(ctx, ep, fc)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		ep.failures = 0
+		ep.healthy = true
+		return
+	}
+	f.failed(ep, fc)
+}
+
+// probe runs the Probe on ep's session fc until ctx is done, after
+// which the unresponsive session is retired.  Closing it also
+// releases a probe stuck waiting on it.
+func (f *failoverClient) probe(ctx context.Context, ep *endpoint, fc *frameClient) error {
+	errc := make(chan error, 1)
+	go func() { errc <- f.opts.Probe(fc) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		f.retire(ep, fc)
+		return ctx.Err()
+	}
+}
+
+// failed records a failure on ep.  f.mu must be held.
+func (f *failoverClient) failed(ep *endpoint, fc *frameClient) {
+	ep.failures++
+	if fc == nil || fc.closed() || ep.failures >= f.opts.MaxFailures {
+		ep.healthy = false
+	}
+}
+
+func (f *failoverClient) checkAll() error {
+	if err := f.resolve(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	eps := append([]*endpoint(nil), f.endpoints...)
+	f.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range eps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.check(ep)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (f *failoverClient) checker() {
+	defer f.wg.Done()
+	t := time.NewTicker(f.opts.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			// Resolution errors keep the current endpoints.
+			f.checkAll()
+		case <-f.closeMarker:
+			return
+		}
+	}
+}
+
+func (f *failoverClient) closed() bool {
+	select {
+	case <-f.closeMarker:
+		return true
+	default:
+	}
+	return false
+}
+
+func (ep *endpoint) usable() bool {
+	return ep.healthy && ep.fc != nil && !ep.fc.closed()
+}
+
+func (f *failoverClient) anyUsable() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ep := range f.endpoints {
+		if ep.usable() {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *failoverClient) Dial() (net.Conn, error) {
+	if f.closed() {
+		return nil, ErrSessionClosed
+	}
+
+	f.mu.Lock()
+	var states []EndpointState
+	byAddr := map[string]*endpoint{}
+	for _, ep := range f.endpoints {
+		if ep.usable() {
+			states = append(states, EndpointState{
+				Addr:         ep.addr,
+				ChannelsOpen: int(ep.fc.GetInfo().ChannelsOpen),
+				Failures:     ep.failures,
+			})
+			byAddr[ep.addr] = ep
+		}
+	}
+	f.mu.Unlock()
+
+	err := ErrNoSessions
+	for _, s := range f.opts.Policy.Order(states) {
+		ep := byAddr[s.Addr]
+		if ep == nil {
+			continue
+		}
+		f.mu.Lock()
+		fc := ep.fc
+		f.mu.Unlock()
+		if fc == nil {
+			continue
+		}
+
+		var c net.Conn
+		c, err = fc.Dial()
+		f.mu.Lock()
+		if err == nil {
+			ep.failures = 0
+		} else {
+			f.failed(ep, fc)
+		}
+		f.mu.Unlock()
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+func (f *failoverClient) GetInfo() Info {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rv := f.retired
+	for _, ep := range f.endpoints {
+		if ep.fc == nil {
+			continue
+		}
+		i := ep.fc.GetInfo()
+		rv.BytesRead += i.BytesRead
+		rv.BytesWritten += i.BytesWritten
+		rv.ChannelsOpen += i.ChannelsOpen
+	}
+	return rv
+}
+
+func (f *failoverClient) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.closeMarker)
+		f.cancel()
+		f.mu.Lock()
+		var sessions []*frameClient
+		for _, ep := range f.endpoints {
+			if ep.fc != nil {
+				sessions = append(sessions, ep.fc)
+			}
+		}
+		f.mu.Unlock()
+		for _, fc := range sessions {
+			fc.Close()
+		}
+	})
+	f.wg.Wait()
+	return nil
+}
@@ -0,0 +1,195 @@
+package frames
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicies(t *testing.T) {
+	t.Parallel()
+	eps := []EndpointState{
+		{Addr: "a", ChannelsOpen: 3},
+		{Addr: "b", ChannelsOpen: 1},
+		{Addr: "c", ChannelsOpen: 2},
+	}
+	addrs := func(eps []EndpointState) string {
+		var rv string
+		for _, e := range eps {
+			rv += e.Addr
+		}
+		return rv
+	}
+
+	rr := RoundRobin()
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, addrs(rr.Order(eps)))
+	}
+	if exp := []string{"abc", "bca", "cab", "abc"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected round robin %v, got %v", exp, got)
+	}
+	if got := addrs(LeastChannels().Order(eps)); got != "bca" {
+		t.Errorf("Expected least channels bca, got %v", got)
+	}
+	if got := addrs(PrimaryBackup().Order(eps)); got != "abc" {
+		t.Errorf("Expected primary/backup abc, got %v", got)
+	}
+	if got := addrs(eps); got != "abc" {
+		t.Errorf("Policies modified their input: %v", got)
+	}
+}
+
+func TestFailoverClient(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc1 := startTestEchoServer(t)
+	defer tc1.l.Close()
+	tc2 := startTestEchoServer(t)
+	defer tc2.l.Close()
+
+	// Nothing listens on the first address.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	dead.Close()
+
+	fd, err := NewFailoverClient(FailoverOptions{
+		Addrs:         []string{dead.Addr().String(), tc1.addr, tc2.addr},
+		CheckInterval: time.Hour,
+		Probe:         func(ChannelDialer) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("Error creating failover client: %v", err)
+	}
+	defer fd.Close()
+	f := fd.(*failoverClient)
+
+	for i := 0; i < 4; i++ {
+		c, err := f.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		defer c.Close()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if a, b := atomic.LoadInt32(&tc1.channels), atomic.LoadInt32(&tc2.channels); a != 2 || b != 2 {
+		t.Errorf("Expected channels spread 2/2, got %v/%v", a, b)
+	}
+
+	// Kill the session to the first live endpoint; channels move
+	// to the other.
+	f.mu.Lock()
+	f.endpoints[1].fc.c.Close()
+	f.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		c, err := f.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing after failure: %v", err)
+		}
+		defer c.Close()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if b := atomic.LoadInt32(&tc2.channels); b != 4 {
+		t.Errorf("Expected 4 channels on the second endpoint, got %v", b)
+	}
+
+	// A health check reconnects it.
+	f.checkAll()
+	f.mu.Lock()
+	if !f.endpoints[1].usable() {
+		t.Errorf("Expected the endpoint to recover")
+	}
+	if f.endpoints[0].usable() {
+		t.Errorf("Expected the dead endpoint to be unusable")
+	}
+	f.mu.Unlock()
+}
+
+func TestFailoverClientNoEndpoints(t *testing.T) {
+	t.Parallel()
+	_, err := NewFailoverClient(FailoverOptions{})
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected invalid option, got %v", err)
+	}
+
+	errNope := errors.New("nope")
+	_, err = NewFailoverClient(FailoverOptions{
+		Resolve: func(context.Context) ([]string, error) { return nil, errNope },
+	})
+	if err != errNope {
+		t.Errorf("Expected resolver error, got %v", err)
+	}
+
+	_, err = NewFailoverClient(FailoverOptions{
+		Addrs: []string{"a", "b"},
+		Dial: func(context.Context, string) (net.Conn, error) {
+			return nil, errNope
+		},
+	})
+	if err != ErrNoSessions {
+		t.Errorf("Expected no sessions, got %v", err)
+	}
+}
+
+// An endpoint that accepts connections but never answers opens must
+// not stall health checks.
+func TestFailoverClientUnresponsive(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc := startTestEchoServer(t)
+	defer tc.l.Close()
+
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, c)
+		}
+	}()
+
+	start := time.Now()
+	fd, err := NewFailoverClient(FailoverOptions{
+		Addrs:         []string{silent.Addr().String(), tc.addr},
+		Policy:        PrimaryBackup(),
+		CheckInterval: time.Hour,
+		CheckTimeout:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Error creating failover client: %v", err)
+	}
+	defer fd.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Initial check took %v", d)
+	}
+
+	f := fd.(*failoverClient)
+	f.mu.Lock()
+	healthy := f.endpoints[0].healthy
+	f.mu.Unlock()
+	if healthy {
+		t.Errorf("Expected the silent endpoint to be unhealthy")
+	}
+	c, err := fd.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	c.Close()
+}
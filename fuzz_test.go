@@ -0,0 +1,148 @@
+package frames
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func fuzzSeeds(f *testing.F) {
+	seeds := [][]FramePacket{
+		{{Cmd: FrameOpen}},
+		{{Cmd: FrameOpen, Status: FrameError, Data: []byte("nope")}},
+		{{Cmd: FrameOpen, Channel: 1}, {Cmd: FrameData, Channel: 1, Data: []byte("hi")}},
+		{{Cmd: FrameOpen, Channel: 1}, {Cmd: FrameClose, Channel: 1}},
+		{{Cmd: FrameData, Channel: 9, Data: []byte("stray")}},
+		{{Cmd: FrameCmd(42)}},
+	}
+	for _, pkts := range seeds {
+		var b bytes.Buffer
+		for _, p := range pkts {
+			b.Write(p.Bytes())
+		}
+		f.Add(b.Bytes())
+	}
+	f.Add([]byte{0xff, 0xff, 0, 0, 2, 0})
+	f.Add([]byte{0, 1})
+}
+
+func waitClosed(t *testing.T, closeMarker chan bool) {
+	select {
+	case <-closeMarker:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session didn't shut down")
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		dec := NewDecoder(bytes.NewReader(input))
+		for {
+			pkt, err := dec.ReadPacket()
+			if err != nil {
+				switch err.(type) {
+				case *ProtocolError:
+				default:
+					if err != io.EOF && err != io.ErrUnexpectedEOF {
+						t.Fatalf("Unexpected error type %T: %v", err, err)
+					}
+				}
+				return
+			}
+			var b bytes.Buffer
+			if err := WritePacket(&b, pkt); err != nil {
+				t.Fatalf("Error re-encoding %v: %v", pkt, err)
+			}
+			again, err := ReadPacket(&b)
+			if err != nil {
+				t.Fatalf("Error decoding re-encoded %v: %v", pkt, err)
+			}
+			if !reflect.DeepEqual(pkt, again) {
+				t.Fatalf("Round trip mismatch: %v != %v", pkt, again)
+			}
+		}
+	})
+}
+
+func FuzzHeader(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		pkt := PacketFromHeader(input)
+		if len(pkt.Data) > maxWriteLen {
+			t.Fatalf("PacketFromHeader(%v) gave %d bytes of data", input, len(pkt.Data))
+		}
+		decoded, err := DecodeHeader(input)
+		if err != nil {
+			if _, ok := err.(*ProtocolError); !ok {
+				t.Fatalf("Unexpected error type %T: %v", err, err)
+			}
+			return
+		}
+		if !reflect.DeepEqual(pkt, decoded) {
+			t.Fatalf("PacketFromHeader(%v) = %v, DecodeHeader gave %v", input, pkt, decoded)
+		}
+	})
+}
+
+func FuzzClientSession(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		c, peer := net.Pipe()
+		fcd, err := NewClientWithOptions(c, ClientOptions{Logger: discardLogger})
+		if err != nil {
+			t.Fatalf("Error creating client: %v", err)
+		}
+		fc := fcd.(*frameClient)
+
+		// Have one open pending so open responses have somewhere to go.
+		go func() {
+			if ch, err := fc.Dial(); err == nil {
+				io.Copy(io.Discard, ch)
+			}
+		}()
+		if _, err := io.ReadFull(peer, make([]byte, minPktLen)); err != nil {
+			t.Fatalf("Error reading open request: %v", err)
+		}
+		go io.Copy(io.Discard, peer)
+
+		peer.Write(input)
+		peer.Close()
+		waitClosed(t, fc.closeMarker)
+	})
+}
+
+func FuzzServerSession(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		c, peer := net.Pipe()
+		l, err := ListenWithOptions(c, ServerOptions{Logger: discardLogger})
+		if err != nil {
+			t.Fatalf("Error listening: %v", err)
+		}
+		fc := l.(*frameConnection)
+
+		go func() {
+			for {
+				ch, err := l.Accept()
+				if err != nil {
+					return
+				}
+				if ch != nil {
+					go io.Copy(io.Discard, ch)
+				}
+			}
+		}()
+		go io.Copy(io.Discard, peer)
+
+		peer.Write(input)
+		peer.Close()
+		waitClosed(t, fc.closeMarker)
+	})
+}
@@ -0,0 +1,138 @@
+package frames
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// SessionInfo identifies a session in hook callbacks.
+type SessionInfo struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	// Server is true for the listening side of a session.
+	Server bool
+}
+
+// ChannelInfo describes a channel in hook callbacks.
+type ChannelInfo struct {
+	Session SessionInfo
+	Channel uint16
+	Opened  time.Time
+	// Duration is how long the channel was open.  It's only set
+	// when the channel is closed.
+	Duration     time.Duration
+	BytesRead    uint64
+	BytesWritten uint64
+	// Protocol is the protocol negotiated when the channel was
+	// opened, if any.  See ClientOptions.Protocols.
+	Protocol string
+}
+
+// ChannelInfoOf describes a channel from a ChannelDialer or a
+// listener, returning false for any other net.Conn.
+func ChannelInfoOf(c net.Conn) (ChannelInfo, bool) {
+	switch ch := c.(type) {
+	case *clientChannel:
+		return ch.info(ch.fc.session, ch.channel), true
+	case *frameChannel:
+		return ch.info(ch.conn.session, ch.channel), true
+	}
+	return ChannelInfo{}, false
+}
+
+// Hooks are optional callbacks for session and channel lifecycle
+// events.  Any nil hook is skipped.
+//
+// Hooks for a session are run in order on a goroutine separate from
+// the session's read and write loops, so a slow hook delays later
+// hooks, but never traffic.  A hook may therefore run after the event
+// it describes has been overtaken, such as ChannelOpened after the
+// channel has already carried data, or closed.
+type Hooks struct {
+	// SessionEstablished is called when a session is created.
+	SessionEstablished func(s SessionInfo)
+	// SessionClosed is called when a session is torn down.  The
+	// cause is nil for a local Close.
+	SessionClosed func(s SessionInfo, cause error)
+	// ChannelOpened is called when a channel is established.
+	ChannelOpened func(c ChannelInfo)
+	// ChannelClosed is called when a channel is closed.
+	ChannelClosed func(c ChannelInfo)
+	// OpenRejected is called when a channel open fails.
+	OpenRejected func(s SessionInfo, err error)
+	// ProtocolError is called when a peer violates the protocol.
+	// SessionClosed follows with the same error.
+	ProtocolError func(s SessionInfo, err *ProtocolError)
+}
+
+// hookRunner runs hooks in order without blocking the caller.
+type hookRunner struct {
+	hooks   Hooks
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (h *hookRunner) run(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending = append(h.pending, f)
+	if !h.running {
+		h.running = true
+		go h.drain()
+	}
+}
+
+func (h *hookRunner) drain() {
+	for {
+		h.mu.Lock()
+		if len(h.pending) == 0 {
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		f := h.pending[0]
+		h.pending[0] = nil
+		h.pending = h.pending[1:]
+		h.mu.Unlock()
+
+		f()
+	}
+}
+
+func (h *hookRunner) sessionEstablished(s SessionInfo) {
+	if f := h.hooks.SessionEstablished; f != nil {
+		h.run(func() { f(s) })
+	}
+}
+
+func (h *hookRunner) sessionClosed(s SessionInfo, cause error) {
+	var pe *ProtocolError
+	if f := h.hooks.ProtocolError; f != nil && errors.As(cause, &pe) {
+		h.run(func() { f(s, pe) })
+	}
+	if f := h.hooks.SessionClosed; f != nil {
+		h.run(func() { f(s, cause) })
+	}
+}
+
+func (h *hookRunner) channelOpened(c ChannelInfo) {
+	if f := h.hooks.ChannelOpened; f != nil {
+		h.run(func() { f(c) })
+	}
+}
+
+func (h *hookRunner) channelClosed(c ChannelInfo) {
+	if f := h.hooks.ChannelClosed; f != nil {
+		c.Duration = time.Since(c.Opened)
+		h.run(func() { f(c) })
+	}
+}
+
+func (h *hookRunner) openRejected(s SessionInfo, err error) {
+	if f := h.hooks.OpenRejected; f != nil {
+		h.run(func() { f(s, err) })
+	}
+}
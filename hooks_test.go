@@ -0,0 +1,153 @@
+package frames
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type hookEvent struct {
+	name string
+	ch   ChannelInfo
+	err  error
+}
+
+func recordingHooks() (Hooks, chan hookEvent) {
+	events := make(chan hookEvent, 100)
+	return Hooks{
+		SessionEstablished: func(s SessionInfo) {
+			events <- hookEvent{name: "established"}
+		},
+		SessionClosed: func(s SessionInfo, cause error) {
+			events <- hookEvent{name: "closed", err: cause}
+		},
+		ChannelOpened: func(c ChannelInfo) {
+			events <- hookEvent{name: "opened", ch: c}
+		},
+		ChannelClosed: func(c ChannelInfo) {
+			events <- hookEvent{name: "chclosed", ch: c}
+		},
+		OpenRejected: func(s SessionInfo, err error) {
+			events <- hookEvent{name: "rejected", err: err}
+		},
+		ProtocolError: func(s SessionInfo, err *ProtocolError) {
+			events <- hookEvent{name: "protocol", err: err}
+		},
+	}, events
+}
+
+func expectEvent(t *testing.T, events chan hookEvent, name string) hookEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.name != name {
+			t.Fatalf("Expected %v event, got %+v", name, e)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %v event", name)
+	}
+	panic("unreachable")
+}
+
+func TestClientHooks(t *testing.T) {
+	t.Parallel()
+	c, peer := net.Pipe()
+
+	hooks, events := recordingHooks()
+	fc, err := NewClientWithOptions(c, ClientOptions{Hooks: hooks})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+
+	wrote := make(chan bool)
+	go func() {
+		ReadPacket(peer)
+		WritePacket(peer, &FramePacket{Cmd: FrameOpen, Status: FrameError})
+		ReadPacket(peer)
+		WritePacket(peer, &FramePacket{Cmd: FrameOpen, Channel: 3})
+		WritePacket(peer, &FramePacket{Cmd: FrameData, Channel: 3, Data: []byte("hello")})
+		ReadPacket(peer)
+		<-wrote
+		WritePacket(peer, &FramePacket{Cmd: FrameCmd(99)})
+	}()
+
+	expectEvent(t, events, "established")
+
+	if _, err := fc.Dial(); err == nil {
+		t.Fatalf("Expected rejected open")
+	}
+	expectEvent(t, events, "rejected")
+
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	e := expectEvent(t, events, "opened")
+	if e.ch.Channel != 3 {
+		t.Errorf("Expected channel 3, got %+v", e.ch)
+	}
+	if _, err := io.ReadFull(ch, make([]byte, 5)); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	ch.Write([]byte("hi"))
+	close(wrote)
+
+	e = expectEvent(t, events, "chclosed")
+	if e.ch.BytesRead != 5 || e.ch.BytesWritten != 2 || e.ch.Duration <= 0 {
+		t.Errorf("Unexpected closed channel info: %+v", e.ch)
+	}
+	e = expectEvent(t, events, "protocol")
+	if _, ok := e.err.(*ProtocolError); !ok {
+		t.Errorf("Expected protocol error, got %v", e.err)
+	}
+	e = expectEvent(t, events, "closed")
+	if _, ok := e.err.(*ProtocolError); !ok {
+		t.Errorf("Expected protocol error cause, got %v", e.err)
+	}
+}
+
+func TestSlowHooksDontStall(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	t.Parallel()
+
+	c, peer := net.Pipe()
+	release := make(chan bool)
+	defer close(release)
+
+	l, err := ListenWithOptions(c, ServerOptions{
+		Hooks: Hooks{
+			ChannelOpened: func(ChannelInfo) { <-release },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			ch, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ch, ch)
+		}
+	}()
+
+	fc := NewClient(peer)
+	defer fc.Close()
+	for i := 0; i < 3; i++ {
+		ch, err := fc.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		ch.Write([]byte("x"))
+		if _, err := io.ReadFull(ch, make([]byte, 1)); err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+	}
+}
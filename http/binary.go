@@ -0,0 +1,632 @@
+package framesweb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+// BinaryProtocol is the channel protocol in which request and response
+// heads are binary encoded.  Clients opt in by offering it in
+// frames.ClientOptions.Protocols; a Server always supports it.
+// Channels without it carry text HTTP/1.1.
+const BinaryProtocol = "framesweb-binary/1"
+
+// binaryMagic starts every binary head, and can't start a text
+// request, so either may be sent on a binary channel.
+const binaryMagic = 0
+
+// Limits on what's accepted in a binary head.
+const (
+	maxBinaryString  = 1 << 16
+	maxBinaryHeaders = 1 << 10
+)
+
+var (
+	errBadBinary    = errors.New("framesweb: malformed binary message")
+	errBodyLength   = errors.New("framesweb: body doesn't match its Content-Length")
+	errHeadTooLarge = errors.New("framesweb: binary head too large")
+)
+
+// binaryMethods are the methods encoded as a single byte.  Their
+// positions (from 1) are on the wire, so they must never change.
+var binaryMethods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE",
+}
+
+// binaryHeaders is the static dictionary of header names.  Their
+// positions (from 1) are on the wire, so they must never change, and
+// new names may only be appended.
+var binaryHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Accept-Ranges",
+	"Age",
+	"Allow",
+	"Authorization",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"Cookie",
+	"Date",
+	"Etag",
+	"Expires",
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"If-Unmodified-Since",
+	"Last-Modified",
+	"Link",
+	"Location",
+	"Origin",
+	"Pragma",
+	"Range",
+	"Referer",
+	"Retry-After",
+	"Server",
+	"Set-Cookie",
+	"Strict-Transport-Security",
+	"Trailer",
+	"Traceparent",
+	"Tracestate",
+	"User-Agent",
+	"Vary",
+	"Via",
+	"Www-Authenticate",
+	"X-Content-Type-Options",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Request-Id",
+}
+
+var (
+	binaryMethodIndex = index(binaryMethods)
+	binaryHeaderIndex = index(binaryHeaders)
+)
+
+func index(names []string) map[string]uint64 {
+	rv := map[string]uint64{}
+	for i, n := range names {
+		rv[n] = uint64(i + 1)
+	}
+	return rv
+}
+
+// Headers that describe the text framing, which binary messages
+// replace.
+var hopHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Te":                true,
+}
+
+// A binaryWriter encodes messages.  Errors are sticky.
+type binaryWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (b *binaryWriter) uvarint(v uint64) {
+	if b.err == nil {
+		_, b.err = b.w.Write(b.buf[:binary.PutUvarint(b.buf[:], v)])
+	}
+}
+
+func (b *binaryWriter) varint(v int64) {
+	if b.err == nil {
+		_, b.err = b.w.Write(b.buf[:binary.PutVarint(b.buf[:], v)])
+	}
+}
+
+func (b *binaryWriter) string(s string) {
+	b.uvarint(uint64(len(s)))
+	if b.err == nil {
+		_, b.err = b.w.WriteString(s)
+	}
+}
+
+// name writes a method or header name, using its index in dict if
+// it's there.
+func (b *binaryWriter) name(s string, dict map[string]uint64) {
+	if i, ok := dict[s]; ok {
+		b.uvarint(i)
+		return
+	}
+	b.uvarint(0)
+	b.string(s)
+}
+
+func (b *binaryWriter) header(h http.Header, skip func(string) bool) {
+	n := 0
+	for k, vs := range h {
+		if !skip(k) {
+			n += len(vs)
+		}
+	}
+	b.uvarint(uint64(n))
+	for k, vs := range h {
+		if skip(k) {
+			continue
+		}
+		for _, v := range vs {
+			b.name(k, binaryHeaderIndex)
+			b.string(v)
+		}
+	}
+}
+
+// chunk writes p as a body chunk.
+func (b *binaryWriter) chunk(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	b.uvarint(uint64(len(p)))
+	if b.err == nil {
+		_, b.err = b.w.Write(p)
+	}
+}
+
+// end ends a body, followed by its trailers.
+func (b *binaryWriter) end(trailer http.Header) {
+	b.uvarint(0)
+	b.header(trailer, func(string) bool { return false })
+}
+
+func (b *binaryWriter) flush() error {
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+	return b.err
+}
+
+// A binaryReader decodes messages.  If left is set, it's how many
+// more bytes of strings may be read.
+type binaryReader struct {
+	r    *bufio.Reader
+	left *int
+}
+
+func (b binaryReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(b.r)
+	return v, unexpected(err)
+}
+
+func (b binaryReader) varint() (int64, error) {
+	v, err := binary.ReadVarint(b.r)
+	return v, unexpected(err)
+}
+
+func (b binaryReader) string() (string, error) {
+	n, err := b.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > maxBinaryString {
+		return "", errBadBinary
+	}
+	if b.left != nil {
+		if n > uint64(*b.left) {
+			return "", errHeadTooLarge
+		}
+		*b.left -= int(n)
+	}
+	p := make([]byte, n)
+	_, err = io.ReadFull(b.r, p)
+	return string(p), unexpected(err)
+}
+
+func (b binaryReader) name(dict []string) (string, error) {
+	i, err := b.uvarint()
+	switch {
+	case err != nil:
+		return "", err
+	case i == 0:
+		return b.string()
+	case i > uint64(len(dict)):
+		return "", errBadBinary
+	}
+	return dict[i-1], nil
+}
+
+func (b binaryReader) header() (http.Header, error) {
+	n, err := b.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBinaryHeaders {
+		return nil, errBadBinary
+	}
+	h := make(http.Header, n)
+	for ; n > 0; n-- {
+		k, err := b.name(binaryHeaders)
+		if err != nil {
+			return nil, err
+		}
+		v, err := b.string()
+		if err != nil {
+			return nil, err
+		}
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		h[k] = append(h[k], v)
+	}
+	return h, nil
+}
+
+// magic reads the byte starting a head.
+func (b binaryReader) magic() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if c != binaryMagic {
+		return errBadBinary
+	}
+	return nil
+}
+
+// unexpected turns an EOF within a message into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A binaryBody reads a chunked body, filling in trailer at its end.
+// A body with a declared length must match it.
+type binaryBody struct {
+	b       binaryReader
+	length  int64 // declared length, or -1
+	read    int64
+	left    uint64
+	done    bool
+	trailer *http.Header
+	eof     func() // called once the body's read, if set
+}
+
+func (r *binaryBody) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.left == 0 {
+		n, err := r.b.uvarint()
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			if r.length >= 0 && r.read != r.length {
+				return 0, errBodyLength
+			}
+			t, err := r.b.header()
+			if err != nil {
+				return 0, err
+			}
+			r.done = true
+			if r.eof != nil {
+				r.eof()
+			}
+			if len(t) > 0 {
+				if *r.trailer == nil {
+					*r.trailer = http.Header{}
+				}
+				for k, vs := range t {
+					(*r.trailer)[k] = vs
+				}
+			}
+			return 0, io.EOF
+		}
+		if r.length >= 0 && n > uint64(r.length-r.read) {
+			return 0, errBodyLength
+		}
+		r.left = n
+	}
+	if uint64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.b.r.Read(p)
+	r.left -= uint64(n)
+	r.read += int64(n)
+	return n, unexpected(err)
+}
+
+func (r *binaryBody) Close() error {
+	return nil
+}
+
+// declaredTrailer returns the trailers named by h's Trailer header,
+// without values.
+func declaredTrailer(h http.Header) http.Header {
+	var rv http.Header
+	for _, v := range h.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = textproto.TrimString(k); k == "" {
+				continue
+			}
+			if rv == nil {
+				rv = http.Header{}
+			}
+			rv[textproto.CanonicalMIMEHeaderKey(k)] = nil
+		}
+	}
+	return rv
+}
+
+// outgoingLength is the length a request body is known to have, or
+// -1.
+func outgoingLength(req *http.Request) int64 {
+	if req.Body == nil || req.Body == http.NoBody {
+		return 0
+	}
+	if req.ContentLength != 0 {
+		return req.ContentLength
+	}
+	return -1
+}
+
+func skipRequestHeader(k string) bool {
+	return hopHeaders[k] || k == "Host"
+}
+
+// useBinary reports whether req may be sent binary encoded on c.
+// Upgrades are always text, as the server hijacks the channel.
+func useBinary(c net.Conn, req *http.Request) bool {
+	ci, _ := frames.ChannelInfoOf(c)
+	return ci.Protocol == BinaryProtocol && req.Method != http.MethodConnect &&
+		req.Header.Get("Upgrade") == ""
+}
+
+// writeBinaryRequest writes req, with its body, to w.  Like
+// Request.Write, it closes the body.
+func writeBinaryRequest(w io.Writer, req *http.Request) (err error) {
+	if req.Body != nil {
+		defer func() {
+			if cerr := req.Body.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	host := req.Host
+	if host == "" && req.URL != nil {
+		host = req.URL.Host
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	b := &binaryWriter{w: bufio.NewWriter(w)}
+	b.w.WriteByte(binaryMagic)
+	b.name(method, binaryMethodIndex)
+	b.string(req.URL.RequestURI())
+	b.string(host)
+	b.header(req.Header, skipRequestHeader)
+	b.varint(outgoingLength(req))
+	if req.Body != nil && req.Body != http.NoBody {
+		buf := make([]byte, 32*1024)
+		for b.err == nil {
+			n, rerr := req.Body.Read(buf)
+			b.chunk(buf[:n])
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+		}
+	}
+	b.end(req.Trailer)
+	return b.flush()
+}
+
+// readBinaryResponse reads the response to req from r.
+func readBinaryResponse(r *bufio.Reader, req *http.Request) (*http.Response, error) {
+	b := binaryReader{r: r}
+	if err := b.magic(); err != nil {
+		return nil, err
+	}
+	code, err := b.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if code < 100 || code > 999 {
+		return nil, errBadBinary
+	}
+	h, err := b.header()
+	if err != nil {
+		return nil, err
+	}
+	length, err := b.varint()
+	if err != nil {
+		return nil, err
+	}
+	res := &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(int(code))),
+		StatusCode:    int(code),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		ContentLength: length,
+		Trailer:       declaredTrailer(h),
+		Request:       req,
+	}
+	if req.Method == "HEAD" {
+		length = -1
+	}
+	res.Body = &binaryBody{b: b, length: length, trailer: &res.Trailer}
+	return res, nil
+}
+
+// readBinaryRequest reads a request from r, once its magic is read.
+// Its head's strings may total at most maxHead bytes.
+func readBinaryRequest(r *bufio.Reader, maxHead int) (*http.Request, error) {
+	b := binaryReader{r: r, left: &maxHead}
+	method, err := b.name(binaryMethods)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := b.string()
+	if err != nil {
+		return nil, err
+	}
+	host, err := b.string()
+	if err != nil {
+		return nil, err
+	}
+	h, err := b.header()
+	if err != nil {
+		return nil, err
+	}
+	length, err := b.varint()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = uri
+	req.Host = host
+	req.Header = h
+	req.ContentLength = length
+	req.Trailer = declaredTrailer(h)
+	req.Body = &binaryBody{b: binaryReader{r: r}, length: length, trailer: &req.Trailer}
+	return req, nil
+}
+
+// A binaryResponseWriter sends a handler's response as a binary
+// message.
+type binaryResponseWriter struct {
+	b           *binaryWriter
+	req         *http.Request
+	header      http.Header
+	conn        *binaryConn // for hijacking, if set
+	wroteHeader bool
+	length      int64 // declared length, or -1
+	written     int64
+}
+
+func (w *binaryResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *binaryResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader || code < 200 {
+		// Informational responses aren't relayed.
+		return
+	}
+	w.wroteHeader = true
+	if _, ok := w.header["Date"]; !ok {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	length := int64(-1)
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			length = n
+		}
+	}
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		length = 0
+	}
+	w.b.w.WriteByte(binaryMagic)
+	w.b.uvarint(uint64(code))
+	w.b.header(w.header, func(k string) bool {
+		return hopHeaders[k] || strings.HasPrefix(k, http.TrailerPrefix)
+	})
+	w.b.varint(length)
+	w.length = length
+}
+
+func (w *binaryResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.conn != nil && w.conn.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.req.Method == "HEAD" {
+		return len(p), nil
+	}
+	if w.length >= 0 && w.written+int64(len(p)) > w.length {
+		return 0, http.ErrContentLength
+	}
+	w.b.chunk(p)
+	if w.b.err != nil {
+		return 0, w.b.err
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+func (w *binaryResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.b.flush()
+}
+
+func (w *binaryResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.conn == nil {
+		return nil, nil, http.ErrNotSupported
+	}
+	if err := w.b.flush(); err != nil {
+		return nil, nil, err
+	}
+	return w.conn.hijack()
+}
+
+// finish completes the response once the handler returns.
+func (w *binaryResponseWriter) finish() error {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Length") == "" {
+			w.header.Set("Content-Length", "0")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.req.Method != "HEAD" && w.length >= 0 && w.written != w.length {
+		// Leave the body unfinished, so the client sees it's short.
+		w.b.flush()
+		return errBodyLength
+	}
+	var trailer http.Header
+	for k := range declaredTrailer(w.header) {
+		if vs, ok := w.header[k]; ok {
+			if trailer == nil {
+				trailer = http.Header{}
+			}
+			trailer[k] = vs
+		}
+	}
+	for k, vs := range w.header {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			if trailer == nil {
+				trailer = http.Header{}
+			}
+			trailer[textproto.CanonicalMIMEHeaderKey(name)] = vs
+		}
+	}
+	w.b.end(trailer)
+	return w.b.flush()
+}
@@ -0,0 +1,176 @@
+package framesweb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestBinaryRequestRoundTrip(t *testing.T) {
+	req, _ := http.NewRequest("PROPFIND", "http://example.com/a%20b?q=1", strings.NewReader("body"))
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Add("X-Custom", "one")
+	req.Header.Add("X-Custom", "two")
+	req.Header.Set("Trailer", "X-Sum")
+	req.Trailer = http.Header{"X-Sum": {"42"}}
+
+	var buf bytes.Buffer
+	if err := writeBinaryRequest(&buf, req); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	r := bufio.NewReader(&buf)
+	if err := (binaryReader{r: r}).magic(); err != nil {
+		t.Fatalf("Error reading magic: %v", err)
+	}
+	got, err := readBinaryRequest(r, http.DefaultMaxHeaderBytes)
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	body, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatalf("Error reading body: %v", err)
+	}
+	if got.Method != "PROPFIND" || got.RequestURI != "/a%20b?q=1" || got.Host != "example.com" ||
+		got.Header.Get("Accept") != "text/plain" || len(got.Header["X-Custom"]) != 2 ||
+		got.ContentLength != 4 || string(body) != "body" || got.Trailer.Get("X-Sum") != "42" {
+		t.Errorf("Decoded request doesn't match: %+v, body %q", got, body)
+	}
+}
+
+func TestBinaryResponseRoundTrip(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	var buf bytes.Buffer
+	rw := &binaryResponseWriter{b: &binaryWriter{w: bufio.NewWriter(&buf)}, req: req,
+		header: http.Header{}}
+	rw.Header().Set("Trailer", "X-Sum")
+	rw.Header().Set("X-Custom", "yes")
+	rw.WriteHeader(http.StatusTeapot)
+	io.WriteString(rw, "hello, ")
+	io.WriteString(rw, "world")
+	rw.Header().Set("X-Sum", "42")
+	rw.Header().Set(http.TrailerPrefix+"X-Late", "late")
+	if err := rw.finish(); err != nil {
+		t.Fatalf("Error finishing: %v", err)
+	}
+
+	res, err := readBinaryResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Error reading body: %v", err)
+	}
+	if res.StatusCode != http.StatusTeapot || res.Header.Get("X-Custom") != "yes" ||
+		res.ContentLength != -1 || string(body) != "hello, world" ||
+		res.Trailer.Get("X-Sum") != "42" || res.Trailer.Get("X-Late") != "late" {
+		t.Errorf("Decoded response doesn't match: %+v, body %q", res, body)
+	}
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	for _, in := range []string{"", "\x01", "\x09", "\x00\x02\xff\xff\xff\xff\x0f"} {
+		if _, err := readBinaryRequest(bufio.NewReader(strings.NewReader(in)), http.DefaultMaxHeaderBytes); err == nil {
+			t.Errorf("Expected error reading %q", in)
+		}
+	}
+}
+
+func TestBinaryNegotiation(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "" {
+			echoTunnel(http.StatusSwitchingProtocols)(w, req)
+			return
+		}
+		ci, _ := ChannelFromContext(req.Context())
+		w.Header().Set("X-Protocol", ci.Protocol)
+		b, _ := io.ReadAll(req.Body)
+		io.WriteString(w, req.Method+" "+req.RequestURI+" "+string(b))
+	})
+
+	// A framesweb Server, and a frames server that doesn't negotiate.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	s := &Server{Handler: handler}
+	go s.Serve(l)
+	defer s.Close()
+
+	ol, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	oll, err := frames.ListenerListener(ol)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	old := httptest.NewUnstartedServer(handler)
+	old.Listener = oll
+	old.Start()
+	defer old.Close()
+
+	tests := []struct {
+		name   string
+		addr   string
+		protos []string
+		want   string
+	}{
+		{"binary", l.Addr().String(), []string{BinaryProtocol}, BinaryProtocol},
+		{"text client", l.Addr().String(), nil, ""},
+		{"text server", ol.Addr().String(), []string{BinaryProtocol}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", test.addr)
+			if err != nil {
+				t.Fatalf("Error connecting: %v", err)
+			}
+			d, err := frames.NewClientWithOptions(c, frames.ClientOptions{Protocols: test.protos})
+			if err != nil {
+				t.Fatalf("Error creating client: %v", err)
+			}
+			defer d.Close()
+			frt := &FramesRoundTripper{Dialer: d}
+
+			req, _ := http.NewRequest("POST", "http://frames/x?y=z", strings.NewReader("body"))
+			res, err := frt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("Error posting: %v", err)
+			}
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if string(b) != "POST /x?y=z body" || res.Header.Get("X-Protocol") != test.want {
+				t.Errorf("Expected %q via %q, got %q via %q",
+					"POST /x?y=z body", test.want, b, res.Header.Get("X-Protocol"))
+			}
+
+			// Upgrades are always text.
+			req, _ = http.NewRequest("GET", "http://frames/ws", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "echo")
+			res, err = frt.RoundTrip(req)
+			if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("Error upgrading: %v, %v", res, err)
+			}
+			rw := res.Body.(io.ReadWriteCloser)
+			defer rw.Close()
+			io.WriteString(rw, "there\n")
+			if line, err := bufio.NewReader(rw).ReadString('\n'); err != nil || line != "hi there\n" {
+				t.Errorf("Expected echo, got %q, %v", line, err)
+			}
+		})
+	}
+}
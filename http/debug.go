@@ -0,0 +1,149 @@
+package framesweb
+
+import (
+	"encoding/json"
+	"expvar"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+var publishOnce sync.Once
+
+// PublishExpvar publishes the process-wide frames.TotalInfo as the
+// "frames" expvar, served by /debug/vars on http.DefaultServeMux.
+// It's safe to call more than once, and does nothing if something
+// else already published a "frames" variable.
+func PublishExpvar() {
+	publishOnce.Do(func() {
+		if expvar.Get("frames") != nil {
+			return
+		}
+		expvar.Publish("frames", expvar.Func(func() interface{} {
+			return frames.TotalInfo()
+		}))
+	})
+}
+
+type debugChannel struct {
+	frames.ChannelState
+	Age      time.Duration `json:"age"`
+	Idle     time.Duration `json:"idle"`
+	Lifetime time.Duration `json:"lifetime,omitempty"`
+}
+
+type debugSession struct {
+	frames.SessionState
+	Channels []debugChannel `json:"channels"`
+}
+
+type debugPage struct {
+	Now            time.Time      `json:"now"`
+	Total          frames.Info    `json:"total"`
+	Sessions       []debugSession `json:"sessions"`
+	RecentlyClosed []debugChannel `json:"recently_closed"`
+}
+
+func debugChannels(now time.Time, chs []frames.ChannelState) []debugChannel {
+	rv := make([]debugChannel, 0, len(chs))
+	for _, c := range chs {
+		dc := debugChannel{
+			ChannelState: c,
+			Age:          now.Sub(c.Opened).Truncate(time.Millisecond),
+			Idle:         now.Sub(c.LastActive).Truncate(time.Millisecond),
+		}
+		if !c.Closed.IsZero() {
+			dc.Lifetime = c.Closed.Sub(c.Opened).Truncate(time.Millisecond)
+		}
+		rv = append(rv, dc)
+	}
+	return rv
+}
+
+func currentDebugPage() debugPage {
+	now := time.Now()
+	rv := debugPage{
+		Now:            now,
+		Total:          frames.TotalInfo(),
+		RecentlyClosed: debugChannels(now, frames.RecentlyClosed()),
+	}
+	for _, s := range frames.Sessions() {
+		rv.Sessions = append(rv.Sessions, debugSession{
+			SessionState: s,
+			Channels:     debugChannels(now, s.Channels),
+		})
+	}
+	return rv
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>frames</title>
+<style>
+body { font-family: sans-serif; font-size: 90%; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>frames</h1>
+<p>{{.Total}} as of {{.Now.Format "2006-01-02 15:04:05.000"}}</p>
+
+<h2>Sessions ({{len .Sessions}})</h2>
+{{range .Sessions}}
+<h3>{{if .Server}}server{{else}}client{{end}} {{.LocalAddr}} &rarr; {{.RemoteAddr}}</h3>
+<p>{{.Info}}</p>
+{{if .Channels}}
+<table>
+<tr><th>Channel</th><th>Name</th><th>Age</th><th>Idle</th><th>Read</th><th>Written</th><th>Queued</th></tr>
+{{range .Channels}}
+<tr><td class="n">{{.Channel}}</td><td>{{.Name}}</td><td class="n">{{.Age}}</td><td class="n">{{.Idle}}</td>
+<td class="n">{{.BytesRead}}</td><td class="n">{{.BytesWritten}}</td><td class="n">{{.Queued}}</td></tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+
+<h2>Recently Closed Channels ({{len .RecentlyClosed}})</h2>
+{{if .RecentlyClosed}}
+<table>
+<tr><th>Channel</th><th>Name</th><th>Closed</th><th>Lifetime</th><th>Read</th><th>Written</th><th>Unread</th></tr>
+{{range .RecentlyClosed}}
+<tr><td class="n">{{.Channel}}</td><td>{{.Name}}</td><td>{{.Closed.Format "15:04:05.000"}}</td><td class="n">{{.Lifetime}}</td>
+<td class="n">{{.BytesRead}}</td><td class="n">{{.BytesWritten}}</td><td class="n">{{.Queued}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// DebugHandler serves a page describing every live frames session
+// and channel in the process, along with recently closed channels.
+// It serves JSON when requested with ?format=json or an Accept header
+// of application/json.
+//
+// It's typically mounted at /debug/frames.
+type DebugHandler struct{}
+
+// ServeHTTP satisfies http.Handler.
+func (DebugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	page := currentDebugPage()
+	if req.FormValue("format") == "json" ||
+		strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(page)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,76 @@
+package framesweb
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dustin/frames"
+)
+
+func TestDebugHandler(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	l, err := frames.ListenerListener(tl)
+	if err != nil {
+		t.Fatalf("Error listen listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			ch, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, ch)
+		}
+	}()
+
+	c, err := net.Dial("tcp", tl.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	fc := frames.NewClient(c)
+	defer fc.Close()
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	DebugHandler{}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/frames?format=json", nil))
+	var page debugPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Error decoding JSON: %v\n%s", err, w.Body)
+	}
+	found := false
+	for _, s := range page.Sessions {
+		if s.LocalAddr == c.LocalAddr().String() && len(s.Channels) == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Didn't find client session in %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	DebugHandler{}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/frames", nil))
+	name := html.EscapeString(ch.(fmt.Stringer).String())
+	if !strings.Contains(w.Body.String(), name) {
+		t.Errorf("Didn't find %v in page:\n%s", ch, w.Body)
+	}
+
+	PublishExpvar()
+	PublishExpvar()
+	if v := expvar.Get("frames"); v == nil || !strings.Contains(v.String(), `"channels"`) {
+		t.Errorf("Expected frames expvar, got %v", v)
+	}
+}
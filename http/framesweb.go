@@ -2,100 +2,278 @@ package framesweb
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/dustin/frames"
 )
 
 // FramesRoundTripper is a RoundTripper over frames.
+//
+// Every request goes to the Dialer's server, whatever its URL's host.
+// OriginRoundTripper picks a session by origin instead.
+//
+// Each request is made on its own channel.  A timeout or cancellation
+// of the request's context resets only that channel; the session
+// carries on.
+//
+// Requests are sent as text HTTP/1.1 unless the channel negotiated
+// BinaryProtocol.
+//
+// When a response switches protocols (101 Switching Protocols) or
+// accepts a CONNECT, its Body is an io.ReadWriteCloser on the channel
+// itself, as with http.Transport.  Timeouts and the request's context
+// no longer apply once the headers are read.
 type FramesRoundTripper struct {
-	Dialer  frames.ChannelDialer
+	Dialer frames.ChannelDialer
+	// Observer is told how requests progress.  Default is a
+	// LogObserver with Timeout and Logger.
+	Observer Observer
+	// Timeout, if positive, is how long a phase of a request may
+	// take before the default Observer logs it as slow.  Nothing is
+	// cancelled.
 	Timeout time.Duration
+	// DialTimeout limits opening the request's channel.
+	DialTimeout time.Duration
+	// WriteTimeout limits writing the request, including its body.
+	WriteTimeout time.Duration
+	// ResponseHeaderTimeout limits waiting for the response headers
+	// once the request is written.
+	ResponseHeaderTimeout time.Duration
+	// BodyTimeout limits reading the whole response body once the
+	// headers are read.
+	BodyTimeout time.Duration
+	// Logger receives slow request warnings and redial failures.
+	// Default is slog.Default().
+	Logger *slog.Logger
+
+	// Redial, if not nil, builds a new Dialer when the session
+	// behind the current one is lost.  Failed attempts back off
+	// exponentially from MinRedial (default 100ms) to MaxRedial
+	// (default 30s), failing requests fast in between and while
+	// another request is redialing.
+	Redial    func(ctx context.Context) (frames.ChannelDialer, error)
+	MinRedial time.Duration
+	MaxRedial time.Duration
+	// MaxRetries is the most times a request is retried.  Default
+	// is 1; negative disables retries.
+	MaxRetries int
+	// RetryPolicy decides whether a failed request is retried.
+	// Default is DefaultRetryPolicy.
+	RetryPolicy func(req *http.Request, err error) bool
+
+	mu         sync.Mutex
+	delay      time.Duration
+	nextRedial time.Time
+	redialing  bool
+}
+
+func (f *FramesRoundTripper) logger() *slog.Logger {
+	if f.Logger == nil {
+		return slog.Default()
+	}
+	return f.Logger
+}
+
+func reqAttrs(req *http.Request) slog.Attr {
+	return slog.Group("req", "method", req.Method, "url", req.URL)
+}
+
+type timeoutError struct {
+	op string
+}
+
+func (e timeoutError) Error() string   { return "framesweb: " + e.op + " timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+// A channelGuard resets a request's channel when the request's
+// context is done or the current phase times out, and reports why.
+type channelGuard struct {
+	c       io.Closer
+	mu      sync.Mutex
+	timer   *time.Timer
 	err     error
+	stopCtx func() bool
+}
+
+func newChannelGuard(ctx context.Context, c io.Closer) *channelGuard {
+	g := &channelGuard{c: c}
+	g.stopCtx = context.AfterFunc(ctx, func() { g.reset(ctx.Err()) })
+	return g
+}
+
+// phase starts a new phase of the request limited to d, if positive.
+func (g *channelGuard) phase(op string, d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if d > 0 {
+		g.timer = time.AfterFunc(d, func() { g.reset(timeoutError{op}) })
+	}
+}
+
+func (g *channelGuard) reset(err error) {
+	g.mu.Lock()
+	first := g.err == nil
+	if first {
+		g.err = err
+	}
+	g.mu.Unlock()
+	if first {
+		g.c.Close()
+	}
+}
+
+// check returns the reason the channel was reset in place of err, if
+// it was.
+func (g *channelGuard) check(err error) error {
+	if err == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	return err
+}
+
+func (g *channelGuard) stop() {
+	g.stopCtx()
+	g.phase("", 0)
 }
 
 type channelBodyCloser struct {
 	rc    io.ReadCloser
 	c     io.Closer
-	frt   *FramesRoundTripper
+	g     *channelGuard
+	obs   Observer
+	done  func()
 	req   *http.Request
 	start time.Time
-	t     *time.Timer
+	n     int64
 }
 
 func (c *channelBodyCloser) Read(b []byte) (int, error) {
-	return c.rc.Read(b)
+	n, err := c.rc.Read(b)
+	c.n += int64(n)
+	if err == io.EOF {
+		// The body's complete; nothing left to time out.
+		c.g.phase("", 0)
+		return n, err
+	}
+	err = c.g.check(err)
+	if err != nil {
+		c.obs.Error(c.req, err)
+	}
+	return n, err
 }
 
 func (c *channelBodyCloser) Close() error {
-	if !c.t.Stop() {
-		log.Printf("framesweb: body of %v %v was closed after %v",
-			c.req.Method, c.req.URL, time.Since(c.start))
-	}
+	c.done()
+	c.g.stop()
+	c.obs.BodyClosed(c.req, c.n, time.Since(c.start))
 	c.rc.Close()
 	return c.c.Close()
 }
 
-// RoundTrip satisfies http.RoundTripper
-func (f *FramesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if f.err != nil {
-		return nil, f.err
-	}
+// dial opens a channel, giving up when ctx is done or DialTimeout
+// passes.
+func (f *FramesRoundTripper) dial(ctx context.Context, d frames.ChannelDialer) (net.Conn, error) {
+	return dialContext(ctx, d, f.DialTimeout)
+}
 
+// roundTrip makes a single attempt at req on a channel from d.
+func (f *FramesRoundTripper) roundTrip(req *http.Request, d frames.ChannelDialer) (*http.Response, error) {
+	ctx := req.Context()
+	obs := f.observer()
 	start := time.Now()
-	sendT := time.AfterFunc(f.Timeout, func() {
-		log.Printf("framesweb: %v request for %v is taking longer than %v",
-			req.Method, req.URL, f.Timeout)
-	})
 
-	c, err := f.Dialer.Dial()
+	done := phaseStarted(obs, req, "dial")
+	c, err := f.dial(ctx, d)
+	done()
 	if err != nil {
-		f.err = err
+		obs.Error(req, err)
 		return nil, err
 	}
+	obs.DialDone(req, time.Since(start))
 
-	err = req.Write(c)
+	start = time.Now()
+	done = phaseStarted(obs, req, "request")
+	bin := useBinary(c, req)
+	g := newChannelGuard(ctx, c)
+	g.phase("request write", f.WriteTimeout)
+	if bin {
+		err = writeBinaryRequest(c, req)
+	} else {
+		err = req.Write(c)
+	}
+	err = g.check(err)
+	done()
 	if err != nil {
-		f.err = err
+		g.stop()
 		c.Close()
+		obs.Error(req, err)
 		return nil, err
 	}
-
-	if !sendT.Stop() {
-		log.Printf("framesweb: completed %v request for %v in %v",
-			req.Method, req.URL, time.Since(start))
-	}
+	obs.RequestWritten(req, time.Since(start))
 
 	start = time.Now()
-	endT := time.AfterFunc(f.Timeout, func() {
-		log.Printf("framesweb: response for %v %v is taking longer than %v",
-			req.Method, req.URL, f.Timeout)
-	})
-
-	b := bufio.NewReader(c)
-	res, err := http.ReadResponse(b, req)
-	if err == nil {
-		res.Body = &channelBodyCloser{
-			res.Body,
-			c,
-			f,
-			req,
-			start,
-			endT}
+	done = phaseStarted(obs, req, "response")
+	g.phase("response header", f.ResponseHeaderTimeout)
+	b := bufio.NewReader(&firstByteReader{c, func() {
+		obs.FirstResponseByte(req, time.Since(start))
+	}})
+	var res *http.Response
+	if bin {
+		res, err = readBinaryResponse(b, req)
 	} else {
-		f.err = err
+		res, err = http.ReadResponse(b, req)
+	}
+	done()
+	if err != nil {
+		g.stop()
 		c.Close()
+		err = g.check(err)
+		obs.Error(req, err)
+		return nil, err
+	}
+
+	start = time.Now()
+	if upgraded(req, res) {
+		// The channel now belongs to the caller for as long as it
+		// likes; the request is done.
+		g.stop()
+		res.Body = &upgradedBody{b: b, c: c, obs: obs, req: req, start: start}
+		return res, nil
+	}
+	g.phase("response body", f.BodyTimeout)
+	res.Body = &channelBodyCloser{
+		rc:    res.Body,
+		c:     c,
+		g:     g,
+		obs:   obs,
+		done:  phaseStarted(obs, req, "body"),
+		req:   req,
+		start: start,
 	}
-	return res, err
+	return res, nil
 }
 
 // NewFramesClient gets an HTTP client that maintains a persistent
-// frames connection.
+// frames connection, reconnecting if it's lost.  Every request is
+// sent to addr, whatever its URL; see OriginRoundTripper for clients
+// of several servers.
 func NewFramesClient(n, addr string) (*http.Client, error) {
 	c, err := net.Dial(n, addr)
 	if err != nil {
@@ -105,6 +283,14 @@ func NewFramesClient(n, addr string) (*http.Client, error) {
 	frt := &FramesRoundTripper{
 		Dialer:  frames.NewClient(c),
 		Timeout: time.Hour,
+		Redial: func(ctx context.Context) (frames.ChannelDialer, error) {
+			var d net.Dialer
+			c, err := d.DialContext(ctx, n, addr)
+			if err != nil {
+				return nil, err
+			}
+			return frames.NewClient(c), nil
+		},
 	}
 
 	hc := &http.Client{
@@ -116,8 +302,11 @@ func NewFramesClient(n, addr string) (*http.Client, error) {
 
 // CloseFramesClient closes the frames client.
 func CloseFramesClient(hc *http.Client) error {
-	if frt, ok := hc.Transport.(*FramesRoundTripper); ok {
-		return frt.Dialer.Close()
+	switch rt := hc.Transport.(type) {
+	case *FramesRoundTripper:
+		return rt.dialer().Close()
+	case *OriginRoundTripper:
+		return rt.Close()
 	}
 	return errors.New("not a frames client")
 }
@@ -0,0 +1,147 @@
+package framesweb
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dustin/frames"
+)
+
+// A MetricsHandler serves the metrics of registered frames clients
+// and listeners in the Prometheus text exposition format.  Each
+// registration is distinguished by a name label.
+type MetricsHandler struct {
+	mu      sync.Mutex
+	metrics map[string]*frames.Metrics
+}
+
+// NewMetricsHandler returns an empty MetricsHandler.
+func NewMetricsHandler() *MetricsHandler {
+	return &MetricsHandler{metrics: map[string]*frames.Metrics{}}
+}
+
+// Register adds metrics to be served under the given name,
+// replacing any previously registered under the same name.
+func (h *MetricsHandler) Register(name string, m *frames.Metrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics[name] = m
+}
+
+// Unregister stops serving the metrics registered under name.
+func (h *MetricsHandler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.metrics, name)
+}
+
+type namedSnapshot struct {
+	name string
+	s    frames.MetricsSnapshot
+}
+
+func (h *MetricsHandler) snapshots() []namedSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rv := make([]namedSnapshot, 0, len(h.metrics))
+	for name, m := range h.metrics {
+		rv = append(rv, namedSnapshot{name, m.Snapshot()})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].name < rv[j].name })
+	return rv
+}
+
+type metricDef struct {
+	name, typ, help string
+	labels          string
+	value           func(frames.MetricsSnapshot) string
+}
+
+func intVal(f func(frames.MetricsSnapshot) int64) func(frames.MetricsSnapshot) string {
+	return func(s frames.MetricsSnapshot) string {
+		return strconv.FormatInt(f(s), 10)
+	}
+}
+
+func uintVal(f func(frames.MetricsSnapshot) uint64) func(frames.MetricsSnapshot) string {
+	return func(s frames.MetricsSnapshot) string {
+		return strconv.FormatUint(f(s), 10)
+	}
+}
+
+var metricDefs = []metricDef{
+	{"frames_sessions_open", "gauge", "Currently open sessions.", "",
+		intVal(func(s frames.MetricsSnapshot) int64 { return s.SessionsOpen })},
+	{"frames_sessions_total", "counter", "Sessions established.", "",
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.SessionsTotal })},
+	{"frames_channels_open", "gauge", "Currently open channels.", "",
+		intVal(func(s frames.MetricsSnapshot) int64 { return s.ChannelsOpen })},
+	{"frames_channels_total", "counter", "Channels opened.", "",
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.ChannelsTotal })},
+	{"frames_bytes_total", "counter", "Bytes transferred, including headers.", `direction="in"`,
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.BytesRead })},
+	{"frames_bytes_total", "", "", `direction="out"`,
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.BytesWritten })},
+	{"frames_frames_total", "counter", "Frames transferred.", `direction="in"`,
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.FramesRead })},
+	{"frames_frames_total", "", "", `direction="out"`,
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.FramesWritten })},
+	{"frames_opens_rejected_total", "counter", "Channel opens rejected.", "",
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.OpensRejected })},
+	{"frames_protocol_errors_total", "counter", "Sessions torn down by protocol violations.", "",
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.ProtocolErrors })},
+	{"frames_stray_frames_total", "counter", "Frames dropped for closed or unknown channels.", "",
+		uintVal(func(s frames.MetricsSnapshot) uint64 { return s.StrayFrames })},
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func labelSet(name, extra string) string {
+	l := `name="` + escapeLabel(name) + `"`
+	if extra != "" {
+		l += "," + extra
+	}
+	return "{" + l + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeHTTP satisfies http.Handler.
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	snaps := h.snapshots()
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	for _, d := range metricDefs {
+		if d.typ != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+		}
+		for _, ns := range snaps {
+			fmt.Fprintf(bw, "%s%s %s\n", d.name, labelSet(ns.name, d.labels), d.value(ns.s))
+		}
+	}
+
+	const hname = "frames_open_latency_seconds"
+	fmt.Fprintf(bw, "# HELP %s Time from Dial to the server's open response.\n", hname)
+	fmt.Fprintf(bw, "# TYPE %s histogram\n", hname)
+	for _, ns := range snaps {
+		l := ns.s.OpenLatency
+		for i, b := range l.Bounds {
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", hname,
+				labelSet(ns.name, `le="`+formatFloat(b)+`"`), l.Counts[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket%s %d\n", hname, labelSet(ns.name, `le="+Inf"`), l.Count)
+		fmt.Fprintf(bw, "%s_sum%s %s\n", hname, labelSet(ns.name, ""), formatFloat(l.Sum))
+		fmt.Fprintf(bw, "%s_count%s %d\n", hname, labelSet(ns.name, ""), l.Count)
+	}
+}
@@ -0,0 +1,83 @@
+package framesweb
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	c, peer := net.Pipe()
+	var cm, sm frames.Metrics
+
+	l, err := frames.ListenWithOptions(peer, frames.ServerOptions{Metrics: &sm})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			ch, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, ch)
+		}
+	}()
+
+	fc, err := frames.NewClientWithOptions(c, frames.ClientOptions{Metrics: &cm})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer fc.Close()
+	if _, err := fc.Dial(); err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+
+	// The writer counts a frame once it's written, which may be after
+	// the reply arrives.
+	for deadline := time.Now().Add(5 * time.Second); cm.Snapshot().FramesWritten < 1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Open frame never counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	h := NewMetricsHandler()
+	h.Register("backend", &cm)
+	h.Register(`odd"name`, &sm)
+	h.Register("gone", &frames.Metrics{})
+	h.Unregister("gone")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	got := w.Body.String()
+
+	for _, want := range []string{
+		"# TYPE frames_sessions_open gauge\n",
+		`frames_sessions_open{name="backend"} 1` + "\n",
+		`frames_channels_total{name="backend"} 1` + "\n",
+		`frames_frames_total{name="backend",direction="out"} 1` + "\n",
+		`frames_bytes_total{name="backend",direction="in"} 22` + "\n",
+		`frames_channels_total{name="odd\"name"} 1` + "\n",
+		`frames_stray_frames_total{name="backend"} 0` + "\n",
+		"# TYPE frames_open_latency_seconds histogram\n",
+		`frames_open_latency_seconds_bucket{name="backend",le="+Inf"} 1` + "\n",
+		`frames_open_latency_seconds_count{name="backend"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "gone") {
+		t.Errorf("Unregistered metrics still served:\n%s", got)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
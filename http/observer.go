@@ -0,0 +1,125 @@
+package framesweb
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// An Observer is told how each attempt at a request made by a
+// FramesRoundTripper progresses.  Durations are of each phase, not
+// cumulative.  Methods are called from the goroutines making the
+// request and reading its body, so they shouldn't block.
+type Observer interface {
+	// DialDone is called once the request's channel is open.
+	DialDone(req *http.Request, d time.Duration)
+	// RequestWritten is called once the request is written.
+	RequestWritten(req *http.Request, d time.Duration)
+	// FirstResponseByte is called when the response starts
+	// arriving, d after the request was written.
+	FirstResponseByte(req *http.Request, d time.Duration)
+	// BodyClosed is called when the response body is closed, with
+	// the bytes read from it and the time since the headers were
+	// read.
+	BodyClosed(req *http.Request, n int64, d time.Duration)
+	// Error is called when an attempt fails, or reading its body
+	// does.
+	Error(req *http.Request, err error)
+}
+
+// A PhaseWatcher is an Observer that also wants to know when each
+// phase starts, so it can act while one is still in progress.
+// PhaseStarted is called with "dial", "request", "response" or
+// "body", and the func it returns when that phase ends, however it
+// ends.
+type PhaseWatcher interface {
+	Observer
+	PhaseStarted(req *http.Request, phase string) (done func())
+}
+
+// phaseStarted tells obs that phase started, if it wants to know.
+func phaseStarted(obs Observer, req *http.Request, phase string) func() {
+	if w, ok := obs.(PhaseWatcher); ok {
+		return w.PhaseStarted(req, phase)
+	}
+	return func() {}
+}
+
+// LogObserver logs phases of requests that are slower than Timeout,
+// both while they're still in progress and once they're done.  It's
+// the default Observer.
+type LogObserver struct {
+	// Timeout is how long any phase may take before it's logged as
+	// slow.  Nothing is logged if it's not positive.
+	Timeout time.Duration
+	// Logger receives the warnings.  Default is slog.Default().
+	Logger *slog.Logger
+}
+
+func (l LogObserver) logger() *slog.Logger {
+	if l.Logger == nil {
+		return slog.Default()
+	}
+	return l.Logger
+}
+
+func (l LogObserver) slow(req *http.Request, what string, d time.Duration, args ...any) {
+	if l.Timeout <= 0 || d < l.Timeout {
+		return
+	}
+	l.logger().Warn("framesweb: slow "+what,
+		append([]any{reqAttrs(req), "elapsed", d, "timeout", l.Timeout}, args...)...)
+}
+
+// PhaseStarted warns if the phase is still going after Timeout.
+func (l LogObserver) PhaseStarted(req *http.Request, phase string) func() {
+	if l.Timeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(l.Timeout, func() {
+		l.logger().Warn("framesweb: "+phase+" is taking longer than timeout",
+			reqAttrs(req), "timeout", l.Timeout)
+	})
+	return func() { t.Stop() }
+}
+
+func (l LogObserver) DialDone(req *http.Request, d time.Duration) {
+	l.slow(req, "dial", d)
+}
+
+func (l LogObserver) RequestWritten(req *http.Request, d time.Duration) {
+	l.slow(req, "request", d)
+}
+
+func (l LogObserver) FirstResponseByte(req *http.Request, d time.Duration) {
+	l.slow(req, "response", d)
+}
+
+func (l LogObserver) BodyClosed(req *http.Request, n int64, d time.Duration) {
+	l.slow(req, "body close", d, "bytes", n)
+}
+
+func (l LogObserver) Error(req *http.Request, err error) {}
+
+func (f *FramesRoundTripper) observer() Observer {
+	if f.Observer == nil {
+		return LogObserver{Timeout: f.Timeout, Logger: f.Logger}
+	}
+	return f.Observer
+}
+
+// A firstByteReader calls first when its first byte is read.
+type firstByteReader struct {
+	r     io.Reader
+	first func()
+}
+
+func (r *firstByteReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 && r.first != nil {
+		r.first()
+		r.first = nil
+	}
+	return n, err
+}
@@ -0,0 +1,176 @@
+package framesweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingObserver) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingObserver) DialDone(req *http.Request, d time.Duration) {
+	r.add("dial %v", req.URL.Path)
+}
+
+func (r *recordingObserver) RequestWritten(req *http.Request, d time.Duration) {
+	r.add("written %v", req.URL.Path)
+}
+
+func (r *recordingObserver) FirstResponseByte(req *http.Request, d time.Duration) {
+	r.add("first byte %v", req.URL.Path)
+}
+
+func (r *recordingObserver) BodyClosed(req *http.Request, n int64, d time.Duration) {
+	r.add("closed %v %v", req.URL.Path, n)
+}
+
+func (r *recordingObserver) Error(req *http.Request, err error) {
+	r.add("error %v", req.URL.Path)
+}
+
+func TestObserver(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	frt := startTestServer(t, mux)
+	var obs recordingObserver
+	frt.Observer = &obs
+	frt.ResponseHeaderTimeout = 10 * time.Millisecond
+
+	if body, err := get(t, frt, context.Background(), "/hello"); err != nil || body != "hello" {
+		t.Fatalf("Expected hello, got %q, %v", body, err)
+	}
+	if _, err := get(t, frt, context.Background(), "/stuck"); err == nil {
+		t.Fatalf("Expected stuck request to time out")
+	}
+
+	want := []string{
+		"dial /hello", "written /hello", "first byte /hello", "closed /hello 5",
+		"dial /stuck", "written /stuck", "error /stuck",
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if fmt.Sprint(obs.events) != fmt.Sprint(want) {
+		t.Errorf("Expected events %q, got %q", want, obs.events)
+	}
+}
+
+func TestLogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	l := LogObserver{Timeout: time.Second, Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	req, _ := http.NewRequest("GET", "http://frames/x", nil)
+
+	l.DialDone(req, time.Millisecond)
+	l.BodyClosed(req, 42, 2*time.Second)
+	if got := buf.String(); strings.Count(got, "\n") != 1 ||
+		!strings.Contains(got, "slow body close") || !strings.Contains(got, "bytes=42") {
+		t.Errorf("Expected just the slow body logged, got %q", got)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// A hung phase is logged while it's still hung.
+func TestLogObserverInFlight(t *testing.T) {
+	t.Parallel()
+	var buf lockedBuffer
+	l := LogObserver{Timeout: 10 * time.Millisecond, Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	req, _ := http.NewRequest("GET", "http://frames/x", nil)
+
+	l.PhaseStarted(req, "dial")()
+	done := l.PhaseStarted(req, "response")
+	defer done()
+	for i := 0; !strings.Contains(buf.String(), "response is taking longer"); i++ {
+		if i > 1000 {
+			t.Fatalf("Expected hung response to be logged, got %q", buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := buf.String(); strings.Contains(got, "dial") {
+		t.Errorf("Expected finished dial not to be logged, got %q", got)
+	}
+}
+
+// The default client logs requests still in flight past its timeout.
+func TestFramesClientLogsInFlight(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	release := make(chan bool)
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	})}
+	go s.Serve(l)
+	defer s.Close()
+
+	hc, err := NewFramesClient("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer CloseFramesClient(hc)
+	frt := hc.Transport.(*FramesRoundTripper)
+	if frt.Timeout != time.Hour {
+		t.Errorf("Expected an hour's timeout by default, got %v", frt.Timeout)
+	}
+	var buf lockedBuffer
+	frt.Timeout = 10 * time.Millisecond
+	frt.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	go func() {
+		if res, err := hc.Get("http://frames/slow"); err == nil {
+			res.Body.Close()
+		}
+	}()
+	for i := 0; !strings.Contains(buf.String(), "response is taking longer"); i++ {
+		if i > 1000 {
+			t.Fatalf("Expected slow response to be logged, got %q", buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+}
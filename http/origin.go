@@ -0,0 +1,282 @@
+package framesweb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+// An OriginRoundTripper makes requests over a frames session per
+// origin (scheme://host:port), created when first needed and closed
+// once idle.  Requests for origins that don't speak frames go to a
+// Fallback transport.
+type OriginRoundTripper struct {
+	// Origins are the origins that speak frames, such as
+	// "http://backend:8675".  Ports default to the scheme's.
+	Origins []string
+	// Dial connects to an origin's address (host:port).  Default is
+	// a TCP net.Dialer.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+	// DialTimeout limits connecting a session.  The connection is
+	// shared, so it isn't bound to the request that needed it;
+	// each request only waits for it until its own context is
+	// done.  Default is 30s.
+	DialTimeout time.Duration
+	// Client configures each session.
+	Client frames.ClientOptions
+	// Configure, if not nil, is called with each origin's round
+	// tripper to set its timeouts, retries and such.
+	Configure func(*FramesRoundTripper)
+	// IdleTimeout is how long a session without channels is kept.
+	// Default is 90s.
+	IdleTimeout time.Duration
+	// Fallback handles requests for other origins.  Default is
+	// http.DefaultTransport.
+	Fallback http.RoundTripper
+
+	mu       sync.Mutex
+	allowed  map[string]bool
+	sessions map[string]*originSession
+}
+
+type originSession struct {
+	ready    chan bool // closed once frt or err is set
+	frt      *FramesRoundTripper
+	err      error
+	mu       sync.Mutex
+	lastUsed time.Time
+	timer    *time.Timer
+	closed   bool
+}
+
+// canonicalOrigin returns u's origin with an explicit port, and its
+// address.
+func canonicalOrigin(u *url.URL) (origin, addr string) {
+	scheme := strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	addr = net.JoinHostPort(host, port)
+	return scheme + "://" + addr, addr
+}
+
+func (o *OriginRoundTripper) idleTimeout() time.Duration {
+	if o.IdleTimeout <= 0 {
+		return 90 * time.Second
+	}
+	return o.IdleTimeout
+}
+
+func (o *OriginRoundTripper) dialTimeout() time.Duration {
+	if o.DialTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return o.DialTimeout
+}
+
+func (o *OriginRoundTripper) fallback() http.RoundTripper {
+	if o.Fallback == nil {
+		return http.DefaultTransport
+	}
+	return o.Fallback
+}
+
+func (o *OriginRoundTripper) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if o.Dial != nil {
+		return o.Dial(ctx, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// isAllowed reports whether origin speaks frames.
+func (o *OriginRoundTripper) isAllowed(origin string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.allowed == nil {
+		o.allowed = map[string]bool{}
+		for _, a := range o.Origins {
+			if u, err := url.Parse(a); err == nil {
+				origin, _ := canonicalOrigin(u)
+				o.allowed[origin] = true
+			}
+		}
+	}
+	return o.allowed[origin]
+}
+
+func (o *OriginRoundTripper) connect(ctx context.Context, addr string) (frames.ChannelDialer, error) {
+	c, err := o.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := frames.NewClientWithOptions(c, o.Client)
+	if err != nil {
+		c.Close()
+	}
+	return d, err
+}
+
+// session returns the round tripper for origin, connecting if there
+// isn't one yet.  ctx only limits waiting for the connection.
+func (o *OriginRoundTripper) session(ctx context.Context, origin, addr string) (*FramesRoundTripper, error) {
+	o.mu.Lock()
+	if o.sessions == nil {
+		o.sessions = map[string]*originSession{}
+	}
+	s, ok := o.sessions[origin]
+	if !ok {
+		s = &originSession{ready: make(chan bool)}
+		o.sessions[origin] = s
+	}
+	o.mu.Unlock()
+
+	if !ok {
+		dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.dialTimeout())
+		go func() {
+			defer cancel()
+			s.err = o.start(dctx, s, origin, addr)
+			close(s.ready)
+		}()
+	}
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+	return s.frt, nil
+}
+
+// start connects s's session, or forgets s if it can't.
+func (o *OriginRoundTripper) start(ctx context.Context, s *originSession, origin, addr string) error {
+	d, err := o.connect(ctx, addr)
+	if err != nil {
+		o.evict(origin, s)
+		return err
+	}
+	s.frt = &FramesRoundTripper{
+		Dialer: d,
+		Redial: func(ctx context.Context) (frames.ChannelDialer, error) {
+			return o.connect(ctx, addr)
+		},
+	}
+	if o.Configure != nil {
+		o.Configure(s.frt)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	s.timer = time.AfterFunc(o.idleTimeout(), func() { o.reap(origin, s) })
+	return nil
+}
+
+// reap closes s if it's been idle long enough, or checks again
+// later.
+func (o *OriginRoundTripper) reap(origin string, s *originSession) {
+	timeout := o.idleTimeout()
+	busy := s.frt.dialer().GetInfo().ChannelsOpen > 0
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	idle := time.Since(s.lastUsed)
+	if busy {
+		idle = 0
+	}
+	if idle < timeout {
+		s.timer.Reset(timeout - idle)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	o.evict(origin, s)
+}
+
+// evict forgets origin's session, if it's still s, and closes it.
+func (o *OriginRoundTripper) evict(origin string, s *originSession) {
+	o.mu.Lock()
+	if o.sessions[origin] == s {
+		delete(o.sessions, origin)
+	}
+	o.mu.Unlock()
+	if s.frt == nil {
+		return
+	}
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.timer.Stop()
+	s.mu.Unlock()
+	if !closed {
+		s.frt.dialer().Close()
+	}
+}
+
+// RoundTrip satisfies http.RoundTripper
+func (o *OriginRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin, addr := canonicalOrigin(req.URL)
+	if !o.isAllowed(origin) {
+		return o.fallback().RoundTrip(req)
+	}
+	frt, err := o.session(req.Context(), origin, addr)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return frt.RoundTrip(req)
+}
+
+// CloseIdleConnections closes sessions without open channels, and
+// the Fallback's idle connections.
+func (o *OriginRoundTripper) CloseIdleConnections() {
+	o.mu.Lock()
+	idle := map[string]*originSession{}
+	for origin, s := range o.sessions {
+		select {
+		case <-s.ready:
+			if s.frt != nil && s.frt.dialer().GetInfo().ChannelsOpen == 0 {
+				idle[origin] = s
+			}
+		default:
+		}
+	}
+	o.mu.Unlock()
+	for origin, s := range idle {
+		o.evict(origin, s)
+	}
+	if c, ok := o.fallback().(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// Close closes every session.
+func (o *OriginRoundTripper) Close() error {
+	o.mu.Lock()
+	sessions := o.sessions
+	o.sessions = nil
+	o.mu.Unlock()
+	for origin, s := range sessions {
+		<-s.ready
+		o.evict(origin, s)
+	}
+	return nil
+}
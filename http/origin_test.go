@@ -0,0 +1,155 @@
+package framesweb
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveNamed serves frames HTTP answering with name, returning its
+// origin.
+func serveNamed(t *testing.T, name string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, name)
+	})}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return "http://" + l.Addr().String()
+}
+
+func TestOriginRoundTripper(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	a, b := serveNamed(t, "a"), serveNamed(t, "b")
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "plain")
+	}))
+	defer plain.Close()
+
+	var dials int32
+	o := &OriginRoundTripper{
+		Origins: []string{a, b},
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		},
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	defer o.Close()
+
+	fetch := func(url string) string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", url, nil)
+		res, err := o.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("Error fetching %v: %v", url, err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	for _, test := range []struct{ url, want string }{
+		{a + "/", "a"},
+		{b + "/x", "b"},
+		{a + "/y", "a"},
+		{plain.URL, "plain"},
+	} {
+		if got := fetch(test.url); got != test.want {
+			t.Errorf("Expected %q from %v, got %q", test.want, test.url, got)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("Expected a session per frames origin, got %v dials", n)
+	}
+
+	// Idle sessions are closed and replaced when needed again.
+	for {
+		o.mu.Lock()
+		n := len(o.sessions)
+		o.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := fetch(a); got != "a" {
+		t.Errorf("Expected a after eviction, got %q", got)
+	}
+	if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Errorf("Expected a new session after eviction, got %v dials", n)
+	}
+}
+
+// A request giving up on a session's connection doesn't fail others
+// waiting for the same one.
+func TestOriginRoundTripperSharedDial(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	a := serveNamed(t, "a")
+	release := make(chan bool)
+	o := &OriginRoundTripper{
+		Origins: []string{a},
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			<-release
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		},
+	}
+	defer o.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", a, nil)
+	first := make(chan error)
+	go func() {
+		_, err := o.RoundTrip(req)
+		first <- err
+	}()
+	for {
+		o.mu.Lock()
+		n := len(o.sessions)
+		o.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan string)
+	go func() {
+		req, _ := http.NewRequest("GET", a, nil)
+		res, err := o.RoundTrip(req)
+		if err != nil {
+			t.Errorf("Error fetching after the first gave up: %v", err)
+			second <- ""
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		second <- string(body)
+	}()
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the first request to be cancelled, got %v", err)
+	}
+	close(release)
+	if got := <-second; got != "a" {
+		t.Errorf("Expected a, got %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package framesweb
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+
+	"github.com/dustin/frames"
+)
+
+var errNoRoute = errors.New("framesweb: no route")
+
+type route struct {
+	host, prefix string
+	rt           *FramesRoundTripper
+}
+
+func (r route) matches(host, path string) bool {
+	if r.host != "" && r.host != host {
+		if h, _, err := net.SplitHostPort(host); err != nil || r.host != h {
+			return false
+		}
+	}
+	if strings.HasSuffix(r.prefix, "/") {
+		return strings.HasPrefix(path, r.prefix)
+	}
+	return path == r.prefix || strings.HasPrefix(path, r.prefix+"/")
+}
+
+// A router picks the round tripper for a request by host and path.
+type router []route
+
+func newRouter(dialers map[string]frames.ChannelDialer, logger *slog.Logger) router {
+	var rv router
+	for pattern, d := range dialers {
+		host, prefix := pattern, "/"
+		if i := strings.Index(pattern, "/"); i >= 0 {
+			host, prefix = pattern[:i], pattern[i:]
+		}
+		rv = append(rv, route{host, prefix, &FramesRoundTripper{Dialer: d, Logger: logger}})
+	}
+	// Host routes win, then the longest prefix.
+	sort.Slice(rv, func(i, j int) bool {
+		if (rv[i].host != "") != (rv[j].host != "") {
+			return rv[i].host != ""
+		}
+		return len(rv[i].prefix) > len(rv[j].prefix)
+	})
+	return rv
+}
+
+func (r router) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, rt := range r {
+		if rt.matches(req.Host, req.URL.Path) {
+			return rt.rt.RoundTrip(req)
+		}
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, errNoRoute
+}
+
+// NewReverseProxy returns a reverse proxy forwarding requests over
+// frames to the dialer routed to by their host and path.
+//
+// Keys of dialers are patterns like those of http.ServeMux without a
+// method: "/api/" routes paths under /api/, "/status" routes just
+// /status (and paths under it), "example.com/" routes a host and
+// "example.com/api/" both.  Routes with hosts take precedence, then
+// the longest matching path.  Requests with no route get a 404.
+//
+// X-Forwarded-For, -Host and -Proto are set, responses are flushed
+// as they stream in, and upgraded connections are relayed.
+func NewReverseProxy(dialers map[string]frames.ChannelDialer) *httputil.ReverseProxy {
+	return NewReverseProxyWithOptions(dialers, ProxyOptions{})
+}
+
+// ProxyOptions configure a reverse proxy from
+// NewReverseProxyWithOptions.  The zero value gives the same behavior
+// as NewReverseProxy.
+type ProxyOptions struct {
+	// Logger receives proxy errors, and what each route's
+	// FramesRoundTripper logs.  Default is slog.Default().
+	Logger *slog.Logger
+}
+
+// NewReverseProxyWithOptions is NewReverseProxy configured by opts.
+func NewReverseProxyWithOptions(dialers map[string]frames.ChannelDialer, opts ProxyOptions) *httputil.ReverseProxy {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetXForwarded()
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = pr.In.Host
+			pr.Out.Host = pr.In.Host
+		},
+		Transport:     newRouter(dialers, logger),
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, errNoRoute) {
+				http.NotFound(w, req)
+				return
+			}
+			logger.Warn("framesweb: proxy error", reqAttrs(req), "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
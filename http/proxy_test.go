@@ -0,0 +1,146 @@
+package framesweb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestReverseProxy(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	backend := func(name string) frames.ChannelDialer {
+		return startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%s %s %s %s", name, req.URL.Path,
+				req.Header.Get("X-Forwarded-Host"), req.Header.Get("X-Forwarded-For"))
+		})).Dialer
+	}
+	release := make(chan bool)
+	streamer := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "" {
+			echoTunnel(http.StatusSwitchingProtocols)(w, req)
+			return
+		}
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second\n")
+	})).Dialer
+
+	proxy := httptest.NewServer(NewReverseProxy(map[string]frames.ChannelDialer{
+		"/":                backend("default"),
+		"/api/":            backend("api"),
+		"/api/v2/":         backend("v2"),
+		"example.com/":     backend("example"),
+		"example.com/api/": backend("example-api"),
+		"/stream":          streamer,
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"", "/x", "default /x"},
+		{"", "/api/x", "api /api/x"},
+		{"", "/api/v2/x", "v2 /api/v2/x"},
+		{"", "/apix", "default /apix"},
+		{"example.com", "/x", "example /x example.com 127.0.0.1"},
+		{"example.com", "/api/x", "example-api /api/x example.com 127.0.0.1"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", proxy.URL+test.path, nil)
+		if test.host != "" {
+			req.Host = test.host
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error requesting %v: %v", test.path, err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.HasPrefix(string(b), test.want) {
+			t.Errorf("Expected %q for %v%v, got %q", test.want, test.host, test.path, b)
+		}
+	}
+
+	// Streamed responses arrive as they're flushed.
+	res, err := http.Get(proxy.URL + "/stream")
+	if err != nil {
+		t.Fatalf("Error streaming: %v", err)
+	}
+	br := bufio.NewReader(res.Body)
+	if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+		t.Errorf("Expected first line before the rest, got %q, %v", line, err)
+	}
+	close(release)
+	if line, err := br.ReadString('\n'); err != nil || line != "second\n" {
+		t.Errorf("Expected second line, got %q, %v", line, err)
+	}
+	res.Body.Close()
+
+	// Upgrades are relayed.
+	req, _ := http.NewRequest("GET", proxy.URL+"/stream/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Error upgrading: %v, %v", res, err)
+	}
+	rw := res.Body.(io.ReadWriteCloser)
+	io.WriteString(rw, "there\n")
+	if line, err := bufio.NewReader(rw).ReadString('\n'); err != nil || line != "hi there\n" {
+		t.Errorf("Expected echo, got %q, %v", line, err)
+	}
+	rw.Close()
+}
+
+func TestReverseProxyNoRoute(t *testing.T) {
+	proxy := httptest.NewServer(NewReverseProxy(map[string]frames.ChannelDialer{
+		"example.com/": nil,
+	}))
+	defer proxy.Close()
+	res, err := http.Get(proxy.URL + "/x")
+	if err != nil {
+		t.Fatalf("Error requesting: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found, got %v", res.Status)
+	}
+}
+
+func TestReverseProxyLogger(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	d := startTestServer(t, http.NotFoundHandler()).Dialer
+	d.Close()
+	var buf lockedBuffer
+	proxy := httptest.NewServer(NewReverseProxyWithOptions(map[string]frames.ChannelDialer{
+		"/": d,
+	}, ProxyOptions{Logger: slog.New(slog.NewTextHandler(&buf, nil))}))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL + "/x")
+	if err != nil {
+		t.Fatalf("Error requesting: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected bad gateway, got %v", res.Status)
+	}
+	if got := buf.String(); !strings.Contains(got, "framesweb: proxy error") {
+		t.Errorf("Expected proxy error logged, got %q", got)
+	}
+}
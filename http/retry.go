@@ -0,0 +1,157 @@
+package framesweb
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+// IsSessionError reports whether err means the frames session a
+// request was made on is gone, as opposed to a failure of just the
+// request's channel.
+func IsSessionError(err error) bool {
+	var pe *frames.ProtocolError
+	return errors.Is(err, frames.ErrSessionClosed) || errors.As(err, &pe)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" ||
+		req.Header.Get("X-Idempotency-Key") != ""
+}
+
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// DefaultRetryPolicy retries idempotent requests whose bodies can be
+// replayed when their session was lost.  Failures of a request's own
+// channel, such as timeouts, aren't retried.
+func DefaultRetryPolicy(req *http.Request, err error) bool {
+	return IsSessionError(err) && isIdempotent(req) && replayable(req)
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	nr := req.Clone(req.Context())
+	nr.Body = body
+	return nr, nil
+}
+
+func (f *FramesRoundTripper) dialer() frames.ChannelDialer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Dialer
+}
+
+var (
+	errRedialBackoff = errors.New("framesweb: waiting to redial")
+	errRedialing     = errors.New("framesweb: redial in progress")
+)
+
+// redial replaces dead with a new Dialer, unless it's already been
+// replaced, another request is already replacing it, or a previous
+// attempt failed too recently.
+func (f *FramesRoundTripper) redial(ctx context.Context, dead frames.ChannelDialer) (frames.ChannelDialer, error) {
+	f.mu.Lock()
+	switch {
+	case f.Dialer != dead:
+		d := f.Dialer
+		f.mu.Unlock()
+		return d, nil
+	case f.redialing:
+		f.mu.Unlock()
+		return nil, errRedialing
+	case time.Now().Before(f.nextRedial):
+		f.mu.Unlock()
+		return nil, errRedialBackoff
+	}
+	f.redialing = true
+	f.mu.Unlock()
+
+	d, err := f.Redial(ctx)
+
+	f.mu.Lock()
+	f.redialing = false
+	if err != nil {
+		lo, hi := f.MinRedial, f.MaxRedial
+		if lo <= 0 {
+			lo = 100 * time.Millisecond
+		}
+		if hi <= 0 {
+			hi = 30 * time.Second
+		}
+		f.delay = min(max(2*f.delay, lo), hi)
+		f.nextRedial = time.Now().Add(jitter(f.delay))
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.Dialer = d
+	f.delay = 0
+	f.mu.Unlock()
+	dead.Close()
+	return d, nil
+}
+
+// RoundTrip satisfies http.RoundTripper
+func (f *FramesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := f.RetryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	retries := f.MaxRetries
+	if retries == 0 {
+		retries = 1
+	}
+
+	d := f.dialer()
+	for attempt := 0; ; attempt++ {
+		res, err := f.roundTrip(req, d)
+		if err == nil {
+			return res, nil
+		}
+		if !IsSessionError(err) {
+			return nil, err
+		}
+		// Without Redial, the Dialer may still recover by itself.
+		if f.Redial != nil {
+			nd, rerr := f.redial(req.Context(), d)
+			if rerr != nil {
+				f.logger().Info("framesweb: redial failed", "err", rerr)
+				return nil, err
+			}
+			d = nd
+		}
+		if attempt >= retries || req.Context().Err() != nil || !policy(req, err) {
+			return nil, err
+		}
+		nr, rerr := rewind(req)
+		if rerr != nil {
+			return nil, err
+		}
+		req = nr
+	}
+}
+
+// jitter returns a random duration within 50% of d, so clients
+// redialing together spread out.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
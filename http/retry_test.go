@@ -0,0 +1,171 @@
+package framesweb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	get, _ := http.NewRequest("GET", "http://frames/", nil)
+	post, _ := http.NewRequest("POST", "http://frames/", strings.NewReader("x"))
+	keyed, _ := http.NewRequest("POST", "http://frames/", strings.NewReader("x"))
+	keyed.Header.Set("Idempotency-Key", "abc")
+	unreplayable, _ := http.NewRequest("PUT", "http://frames/", io.NopCloser(strings.NewReader("x")))
+
+	tests := []struct {
+		req *http.Request
+		err error
+		exp bool
+	}{
+		{get, frames.ErrSessionClosed, true},
+		{get, &frames.ProtocolError{Reason: "bad"}, true},
+		{get, timeoutError{"dial"}, false},
+		{post, frames.ErrSessionClosed, false},
+		{keyed, frames.ErrSessionClosed, true},
+		{unreplayable, frames.ErrSessionClosed, false},
+	}
+	for _, test := range tests {
+		if got := DefaultRetryPolicy(test.req, test.err); got != test.exp {
+			t.Errorf("DefaultRetryPolicy(%v %v, %v) = %v, want %v",
+				test.req.Method, test.req.Header, test.err, got, test.exp)
+		}
+	}
+}
+
+func TestRoundTripRecovers(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(w, req.Body)
+	})}
+	go s.Serve(l)
+	defer s.Close()
+
+	var conns []net.Conn
+	var failing int32
+	var redials int32
+	redial := func(ctx context.Context) (frames.ChannelDialer, error) {
+		atomic.AddInt32(&redials, 1)
+		if atomic.LoadInt32(&failing) != 0 {
+			return nil, errors.New("nope")
+		}
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", l.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c)
+		return frames.NewClient(c), nil
+	}
+	d, err := redial(context.Background())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	frt := &FramesRoundTripper{Dialer: d, Redial: redial, MinRedial: time.Hour}
+	defer frt.dialer().Close()
+	hc := &http.Client{Transport: frt}
+
+	post := func(body string) (string, error) {
+		res, err := hc.Post("http://frames/", "text/plain", strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	// A replayable, idempotent request is retried on a new session.
+	conns[0].Close()
+	req, _ := http.NewRequest("PUT", "http://frames/", strings.NewReader("again"))
+	res, err := hc.Do(req)
+	if err != nil {
+		t.Fatalf("Expected PUT to be retried, got %v", err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "again" {
+		t.Errorf("Expected retried body, got %q", b)
+	}
+
+	// A POST isn't retried, but the session is still rebuilt.
+	conns[1].Close()
+	if _, err := post("once"); !IsSessionError(err) {
+		t.Errorf("Expected session error for POST, got %v", err)
+	}
+	if got, err := post("twice"); err != nil || got != "twice" {
+		t.Errorf("Expected POST on rebuilt session, got %q, %v", got, err)
+	}
+
+	// Failed redials back off, failing fast in between.
+	atomic.StoreInt32(&failing, 1)
+	conns[2].Close()
+	before := atomic.LoadInt32(&redials)
+	for i := 0; i < 3; i++ {
+		if _, err := post("nope"); !IsSessionError(err) {
+			t.Errorf("Expected session error while redial fails, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&redials) - before; n != 1 {
+		t.Errorf("Expected 1 redial attempt during backoff, got %v", n)
+	}
+}
+
+// A slow redial doesn't hold up other requests, which fail fast
+// rather than redialing too.
+func TestRedialSingleFlight(t *testing.T) {
+	t.Parallel()
+	c, s := net.Pipe()
+	s.Close()
+	dead := frames.NewClient(c)
+	defer dead.Close()
+	started := make(chan bool)
+	release := make(chan bool)
+	var redials int32
+	frt := &FramesRoundTripper{Dialer: dead,
+		Redial: func(ctx context.Context) (frames.ChannelDialer, error) {
+			atomic.AddInt32(&redials, 1)
+			close(started)
+			<-release
+			return nil, errors.New("nope")
+		}}
+
+	done := make(chan error)
+	go func() {
+		_, err := frt.redial(context.Background(), dead)
+		done <- err
+	}()
+	<-started
+
+	if frt.dialer() != dead {
+		t.Errorf("Expected the current dialer while redialing")
+	}
+	if _, err := frt.redial(context.Background(), dead); err != errRedialing {
+		t.Errorf("Expected redial in progress, got %v", err)
+	}
+	close(release)
+	if err := <-done; err == nil {
+		t.Errorf("Expected the redial to fail")
+	}
+	if _, err := frt.redial(context.Background(), dead); err != errRedialBackoff {
+		t.Errorf("Expected backoff after a failed redial, got %v", err)
+	}
+	if n := atomic.LoadInt32(&redials); n != 1 {
+		t.Errorf("Expected 1 redial, got %v", n)
+	}
+}
@@ -0,0 +1,120 @@
+package framesweb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+// startTestServer serves mux over frames, returning a round tripper
+// connected to it.
+func startTestServer(t *testing.T, mux http.Handler) *FramesRoundTripper {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	s := &Server{Handler: mux}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	frt := &FramesRoundTripper{Dialer: frames.NewClient(c)}
+	t.Cleanup(func() { frt.Dialer.Close() })
+	return frt
+}
+
+func expectTimeout(t *testing.T, err error) {
+	t.Helper()
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+func get(t *testing.T, rt http.RoundTripper, ctx context.Context, path string) (string, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://frames"+path, nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	return string(b), err
+}
+
+func TestRoundTripTimeouts(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	stuck := make(chan bool)
+	defer close(stuck)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/noheaders", func(w http.ResponseWriter, req *http.Request) {
+		<-stuck
+	})
+	mux.HandleFunc("/nobody", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		<-stuck
+	})
+	frt := startTestServer(t, mux)
+	frt.ResponseHeaderTimeout = 20 * time.Millisecond
+	frt.BodyTimeout = 20 * time.Millisecond
+
+	_, err := get(t, frt, context.Background(), "/noheaders")
+	expectTimeout(t, err)
+
+	_, err = get(t, frt, context.Background(), "/nobody")
+	expectTimeout(t, err)
+
+	// The session survives.
+	if got, err := get(t, frt, context.Background(), "/ok"); err != nil || got != "ok" {
+		t.Errorf("Expected ok after timeouts, got %q, %v", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	frt.ResponseHeaderTimeout = 0
+	if _, err := get(t, frt, ctx, "/noheaders"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+	if got, err := get(t, frt, context.Background(), "/ok"); err != nil || got != "ok" {
+		t.Errorf("Expected ok after cancellation, got %q, %v", got, err)
+	}
+}
+
+type stuckDialer struct {
+	frames.ChannelDialer
+	release chan bool
+}
+
+func (d stuckDialer) Dial() (net.Conn, error) {
+	<-d.release
+	return nil, errors.New("released")
+}
+
+func TestRoundTripDialTimeout(t *testing.T) {
+	d := stuckDialer{release: make(chan bool)}
+	defer close(d.release)
+	frt := &FramesRoundTripper{Dialer: d, DialTimeout: 10 * time.Millisecond}
+	_, err := get(t, frt, context.Background(), "/")
+	expectTimeout(t, err)
+}
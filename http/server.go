@@ -0,0 +1,513 @@
+package framesweb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+type contextKey int
+
+const channelKey contextKey = iota
+
+// ChannelFromContext describes the frames channel a request handled
+// by a Server arrived on.
+func ChannelFromContext(ctx context.Context) (frames.ChannelInfo, bool) {
+	c, ok := ctx.Value(channelKey).(net.Conn)
+	if !ok {
+		return frames.ChannelInfo{}, false
+	}
+	return frames.ChannelInfoOf(c)
+}
+
+// SessionFromContext describes the frames session a request handled
+// by a Server arrived on.
+func SessionFromContext(ctx context.Context) (frames.SessionInfo, bool) {
+	ci, ok := ChannelFromContext(ctx)
+	return ci.Session, ok
+}
+
+// A Server serves HTTP over frames sessions accepted from TCP
+// connections.
+//
+// Handlers may take over a request's channel with http.Hijacker, as
+// for WebSockets or CONNECT tunnels.  Closing the hijacked connection
+// closes the channel.
+type Server struct {
+	// Addr is the TCP address to listen on for ListenAndServe.
+	Addr string
+	// Handler handles requests.  Default is http.DefaultServeMux.
+	Handler http.Handler
+	// Options configure each frames session.
+	Options frames.ServerOptions
+
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout
+	// are as for http.Server, applied per channel.
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxHeaderBytes is as for http.Server, and also limits the
+	// headers of binary requests.
+	MaxHeaderBytes int
+	// ErrorLog logs errors from handlers, such as panics.  Default
+	// is the log package's standard logger.
+	ErrorLog *log.Logger
+
+	mu         sync.Mutex
+	srv        *http.Server
+	ctx        context.Context // cancelled by Close
+	cancel     context.CancelFunc
+	listeners  map[net.Listener]bool
+	binary     map[*binaryConn]bool // binary channels being served
+	binaryWG   sync.WaitGroup
+	inShutdown bool
+}
+
+func (s *Server) httpServer() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.srv = &http.Server{
+			Handler:           s.Handler,
+			ReadTimeout:       s.ReadTimeout,
+			ReadHeaderTimeout: s.ReadHeaderTimeout,
+			WriteTimeout:      s.WriteTimeout,
+			IdleTimeout:       s.IdleTimeout,
+			MaxHeaderBytes:    s.MaxHeaderBytes,
+			ErrorLog:          s.ErrorLog,
+			BaseContext: func(net.Listener) context.Context {
+				return s.ctx
+			},
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				if bc, ok := c.(*bufferedConn); ok {
+					c = bc.Conn
+				}
+				return context.WithValue(ctx, channelKey, c)
+			},
+		}
+		s.listeners = map[net.Listener]bool{}
+		s.binary = map[*binaryConn]bool{}
+	}
+	return s.srv
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// idleTimeout is IdleTimeout, or ReadTimeout, as in http.Server.
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout != 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return http.DefaultMaxHeaderBytes
+}
+
+// readHeaderTimeout is ReadHeaderTimeout, or ReadTimeout.
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout != 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+// ListenAndServe listens on s.Addr and serves frames sessions
+// accepted there.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves frames sessions accepted from l.  It always returns a
+// non-nil error: http.ErrServerClosed after Shutdown or Close, or the
+// error that stopped l accepting.
+func (s *Server) Serve(l net.Listener) error {
+	opts := s.Options
+	opts.Protocols = append([]string{BinaryProtocol}, opts.Protocols...)
+	ll, err := frames.ListenerListenerWithOptions(l, opts)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	srv := s.httpServer()
+	s.mu.Lock()
+	s.listeners[ll] = true
+	s.mu.Unlock()
+	return srv.Serve(newBinaryListener(s, ll))
+}
+
+func (s *Server) closeSessions() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for ll := range s.listeners {
+		errs = append(errs, frames.CloseSessions(ll))
+		delete(s.listeners, ll)
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops accepting sessions and channels, closes idle
+// channels, waits for in-flight requests to complete (or ctx to be
+// done), then closes every frames session.
+func (s *Server) Shutdown(ctx context.Context) error {
+	srv := s.httpServer()
+	s.mu.Lock()
+	s.inShutdown = true
+	for bc := range s.binary {
+		if !bc.active {
+			bc.c.Close()
+		}
+	}
+	s.mu.Unlock()
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = s.waitBinary(ctx)
+	}
+	return errors.Join(err, s.closeSessions())
+}
+
+// Close immediately closes every channel and session, and cancels
+// the contexts of requests in flight.
+func (s *Server) Close() error {
+	srv := s.httpServer()
+	s.mu.Lock()
+	s.inShutdown = true
+	s.cancel()
+	for bc := range s.binary {
+		bc.c.Close()
+	}
+	s.mu.Unlock()
+	err := srv.Close()
+	return errors.Join(err, s.closeSessions())
+}
+
+// A bufferedConn is a connection whose first bytes were peeked.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// A binaryListener serves channels carrying binary requests itself,
+// and hands the rest to the http.Server accepting from it.
+type binaryListener struct {
+	net.Listener
+	s     *Server
+	conns chan net.Conn
+	done  chan bool // closed once the frames listener fails
+	err   error
+}
+
+func newBinaryListener(s *Server, l net.Listener) *binaryListener {
+	bl := &binaryListener{
+		Listener: l,
+		s:        s,
+		conns:    make(chan net.Conn),
+		done:     make(chan bool),
+	}
+	go bl.pump()
+	return bl
+}
+
+func (l *binaryListener) pump() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		if ci, _ := frames.ChannelInfoOf(c); ci.Protocol == BinaryProtocol {
+			go l.sniff(c)
+			continue
+		}
+		l.hand(c)
+	}
+}
+
+func (l *binaryListener) hand(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// sniff tells whether a channel's first request is binary.  Clients
+// may still send text, such as for upgrades.
+func (l *binaryListener) sniff(c net.Conn) {
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(deadline(time.Now(), l.s.idleTimeout()))
+	first, err := r.Peek(1)
+	switch {
+	case err != nil:
+		c.Close()
+	case first[0] == binaryMagic:
+		bc := &binaryConn{s: l.s, c: c, r: r, w: bufio.NewWriter(c)}
+		if !l.s.addBinary(bc) {
+			c.Close()
+			return
+		}
+		bc.serve()
+	default:
+		l.hand(&bufferedConn{c, r})
+	}
+}
+
+func (l *binaryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// addBinary tracks bc until removeBinary, unless the server is
+// shutting down.
+func (s *Server) addBinary(bc *binaryConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return false
+	}
+	s.binary[bc] = true
+	s.binaryWG.Add(1)
+	return true
+}
+
+func (s *Server) removeBinary(bc *binaryConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.binary[bc] {
+		delete(s.binary, bc)
+		s.binaryWG.Done()
+	}
+}
+
+// setActive marks bc as serving a request or idle, reporting false
+// if it should close as the server is shutting down.
+func (s *Server) setActive(bc *binaryConn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bc.active = active
+	return !s.inShutdown
+}
+
+// waitBinary waits for binary channels to finish.
+func (s *Server) waitBinary(ctx context.Context) error {
+	done := make(chan bool)
+	go func() {
+		s.binaryWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deadline is d after t, or none if d is zero.
+func deadline(t time.Time, d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return t.Add(d)
+}
+
+// A binaryConn is a channel carrying binary requests.
+type binaryConn struct {
+	s      *Server
+	c      net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	active bool // guarded by s.mu
+
+	deadline time.Time          // the read deadline, when not aborting a read
+	cancel   context.CancelFunc // cancels the current request's context
+	bgRead   chan bool          // closed once a background read ends
+	aborting atomic.Bool
+	hijacked bool
+}
+
+// serve serves binary requests from bc until it's closed.
+func (bc *binaryConn) serve() {
+	s := bc.s
+	defer s.removeBinary(bc)
+	defer func() {
+		if !bc.hijacked {
+			bc.c.Close()
+		}
+	}()
+	for {
+		bc.setReadDeadline(deadline(time.Now(), s.idleTimeout()))
+		if err := (binaryReader{r: bc.r}).magic(); err != nil {
+			return
+		}
+		if !s.setActive(bc, true) {
+			return
+		}
+		start := time.Now()
+		bc.setReadDeadline(deadline(start, s.readHeaderTimeout()))
+		req, err := readBinaryRequest(bc.r, s.maxHeaderBytes())
+		if err == errHeadTooLarge {
+			bc.c.SetWriteDeadline(deadline(start, s.WriteTimeout))
+			bc.reject(http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		if err != nil {
+			return
+		}
+		bc.setReadDeadline(deadline(start, s.ReadTimeout))
+		bc.c.SetWriteDeadline(deadline(start, s.WriteTimeout))
+		if !bc.serveRequest(req) || !s.setActive(bc, false) {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler for req, reporting whether the
+// channel may carry another request.
+func (bc *binaryConn) serveRequest(req *http.Request) (ok bool) {
+	s := bc.s
+	ctx := context.WithValue(s.ctx, http.ServerContextKey, s.httpServer())
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, bc.c.LocalAddr())
+	ctx, bc.cancel = context.WithCancel(context.WithValue(ctx, channelKey, bc.c))
+	defer bc.cancel()
+	req = req.WithContext(ctx)
+	req.RemoteAddr = bc.c.RemoteAddr().String()
+
+	// Once the body's read, watch for the channel closing.  An empty
+	// body is read up front, so the watch covers the whole request.
+	body := req.Body.(*binaryBody)
+	body.eof = bc.startBackgroundRead
+	if req.ContentLength == 0 {
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return false
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				buf := make([]byte, 64<<10)
+				buf = buf[:runtime.Stack(buf, false)]
+				s.logf("framesweb: panic serving %v: %v\n%s", req.RemoteAddr, p, buf)
+			}
+			ok = false
+		}
+	}()
+
+	h := s.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	rw := &binaryResponseWriter{b: &binaryWriter{w: bc.w}, req: req, header: http.Header{}, conn: bc}
+	h.ServeHTTP(rw, req)
+	if bc.hijacked {
+		return false
+	}
+	bc.abortBackgroundRead()
+	if rw.finish() != nil {
+		return false
+	}
+	// Skip what the handler didn't read to reach the next request,
+	// unless there's too much of it.
+	_, err := io.CopyN(io.Discard, req.Body, 256<<10)
+	return err == io.EOF
+}
+
+// startBackgroundRead cancels the current request's context if the
+// channel is closed or reset before the handler returns.
+func (bc *binaryConn) startBackgroundRead() {
+	done := make(chan bool)
+	bc.bgRead = done
+	go func() {
+		defer close(done)
+		if _, err := bc.r.Peek(1); err != nil && !bc.aborting.Load() {
+			bc.cancel()
+		}
+	}()
+}
+
+// abortBackgroundRead stops any background read, so the channel may
+// be read again.
+func (bc *binaryConn) abortBackgroundRead() {
+	if bc.bgRead == nil {
+		return
+	}
+	bc.aborting.Store(true)
+	bc.c.SetReadDeadline(time.Unix(1, 0))
+	<-bc.bgRead
+	bc.bgRead = nil
+	bc.aborting.Store(false)
+	bc.c.SetReadDeadline(bc.deadline)
+}
+
+// setReadDeadline sets the channel's read deadline, remembering it
+// for after a background read's aborted.
+func (bc *binaryConn) setReadDeadline(t time.Time) {
+	bc.deadline = t
+	bc.c.SetReadDeadline(t)
+}
+
+// reject responds with an error status to a request that couldn't be
+// read.
+func (bc *binaryConn) reject(code int) {
+	rw := &binaryResponseWriter{b: &binaryWriter{w: bc.w}, req: &http.Request{Method: "GET"},
+		header: http.Header{}}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(code)
+	io.WriteString(rw, http.StatusText(code))
+	rw.finish()
+}
+
+// hijack hands the channel over to a handler.
+func (bc *binaryConn) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if bc.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	bc.abortBackgroundRead()
+	bc.hijacked = true
+	bc.s.removeBinary(bc)
+	return bc.c, bufio.NewReadWriter(bc.r, bc.w), nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves HTTP
+// from handler over frames sessions accepted there.
+func ListenAndServe(addr string, handler http.Handler) error {
+	s := &Server{Addr: addr, Handler: handler}
+	return s.ListenAndServe()
+}
@@ -0,0 +1,426 @@
+package framesweb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestServerShutdown(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/who", func(w http.ResponseWriter, req *http.Request) {
+		ci, ok := ChannelFromContext(req.Context())
+		si, _ := SessionFromContext(req.Context())
+		fmt.Fprintf(w, "%v %v %v", ok, ci.Channel, si.Server)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	s := &Server{Handler: mux}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	hc, err := NewFramesClient("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer CloseFramesClient(hc)
+
+	res, err := hc.Get("http://frames/who")
+	if err != nil {
+		t.Fatalf("Error getting: %v", err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if got := string(b); got != "true 1 true" {
+		t.Errorf("Expected request context to describe channel 1, got %q", got)
+	}
+
+	slow := make(chan string, 1)
+	go func() {
+		res, err := hc.Get("http://frames/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		slow <- string(b)
+	}()
+	<-started
+
+	shut := make(chan error, 1)
+	go func() { shut <- s.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shut:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-slow; got != "done" {
+		t.Errorf("Expected in-flight request to complete, got %q", got)
+	}
+	if err := <-shut; err != nil {
+		t.Errorf("Error shutting down: %v", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Expected server closed, got %v", err)
+	}
+
+	// The session is gone.
+	if _, err := hc.Get("http://frames/who"); err == nil {
+		t.Errorf("Expected requests to fail after shutdown")
+	}
+}
+
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (f failingListener) Accept() (net.Conn, error) {
+	return nil, f.err
+}
+
+func TestServerAcceptError(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	want := errors.New("accept broke")
+	s := &Server{}
+	if err := s.Serve(failingListener{l, want}); err != want {
+		t.Errorf("Expected %v from Serve, got %v", want, err)
+	}
+}
+
+// binaryChannel opens a binary channel to a Server at addr.
+func binaryChannel(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	d, err := frames.NewClientWithOptions(c, frames.ClientOptions{Protocols: []string{BinaryProtocol}})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	ch, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error opening channel: %v", err)
+	}
+	return ch
+}
+
+// binaryGet sends a binary GET of path on c, returning the response.
+func binaryGet(t *testing.T, c net.Conn, path string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "http://frames"+path, nil)
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	res, err := readBinaryResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	return res
+}
+
+func TestServerBinaryShutdown(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	started := make(chan bool)
+	cancelled := make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/wait", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+	})
+	s := &Server{Handler: mux}
+	go s.Serve(l)
+
+	// An idle channel, having served a request.
+	idle := binaryChannel(t, l.Addr().String())
+	res := binaryGet(t, idle, "/ok")
+	if b, err := io.ReadAll(res.Body); err != nil || string(b) != "ok" {
+		t.Fatalf("Expected ok, got %q, %v", b, err)
+	}
+
+	// Closing a channel cancels its request.
+	c := binaryChannel(t, l.Addr().String())
+	req, _ := http.NewRequest("GET", "http://frames/wait", nil)
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	<-started
+	c.Close()
+	<-cancelled
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Error shutting down: %v", err)
+	}
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected idle channel closed by shutdown")
+	}
+}
+
+func TestServerBinaryClose(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	started := make(chan bool)
+	cancelled := make(chan bool)
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+	})}
+	go s.Serve(l)
+
+	// The handler never reads the body, so the channel isn't
+	// watched, and only Close can cancel.
+	c := binaryChannel(t, l.Addr().String())
+	req, _ := http.NewRequest("POST", "http://frames/", strings.NewReader("body"))
+	req.ContentLength = -1
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	<-started
+	s.Close()
+	<-cancelled
+}
+
+func TestServerBinaryHijack(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, bw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Error hijacking: %v", err)
+			return
+		}
+		defer c.Close()
+		bw.WriteString("hijacked\n")
+		bw.Flush()
+		line, _ := bw.ReadString('\n')
+		bw.WriteString("hi " + line)
+		bw.Flush()
+	})}
+	go s.Serve(l)
+	defer s.Close()
+
+	c := binaryChannel(t, l.Addr().String())
+	req, _ := http.NewRequest("GET", "http://frames/", nil)
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	r := bufio.NewReader(c)
+	if line, err := r.ReadString('\n'); err != nil || line != "hijacked\n" {
+		t.Fatalf("Expected hijacked, got %q, %v", line, err)
+	}
+	io.WriteString(c, "there\n")
+	if line, err := r.ReadString('\n'); err != nil || line != "hi there\n" {
+		t.Errorf("Expected echo, got %q, %v", line, err)
+	}
+}
+
+func TestServerBinaryContentLength(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	errs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		io.WriteString(w, "short")
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		io.WriteString(w, "ok")
+		_, err := io.WriteString(w, "more")
+		errs <- err
+	})
+	mux.HandleFunc("/body", func(w http.ResponseWriter, req *http.Request) {
+		_, err := io.ReadAll(req.Body)
+		errs <- err
+	})
+	s := &Server{Handler: mux}
+	go s.Serve(l)
+	defer s.Close()
+
+	res := binaryGet(t, binaryChannel(t, l.Addr().String()), "/short")
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Errorf("Expected error reading a short body")
+	}
+
+	res = binaryGet(t, binaryChannel(t, l.Addr().String()), "/long")
+	if b, err := io.ReadAll(res.Body); err != nil || string(b) != "ok" {
+		t.Errorf("Expected ok, got %q, %v", b, err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrContentLength) {
+		t.Errorf("Expected ErrContentLength writing too much, got %v", err)
+	}
+
+	req, _ := http.NewRequest("POST", "http://frames/body", strings.NewReader("short"))
+	req.ContentLength = 10
+	go writeBinaryRequest(binaryChannel(t, l.Addr().String()), req)
+	if err := <-errs; err != errBodyLength {
+		t.Errorf("Expected errBodyLength reading a short request, got %v", err)
+	}
+}
+
+func TestServerBinaryErrorLog(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	var buf lockedBuffer
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic("oops")
+		}),
+		ErrorLog:    log.New(&buf, "", 0),
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	req, _ := http.NewRequest("GET", "http://frames/", nil)
+	c := binaryChannel(t, l.Addr().String())
+	writeBinaryRequest(c, req)
+	if _, err := readBinaryResponse(bufio.NewReader(c), req); err == nil {
+		t.Errorf("Expected no response from a panicking handler")
+	}
+	if got := buf.String(); !strings.Contains(got, "framesweb: panic serving") {
+		t.Errorf("Expected panic in ErrorLog, got %q", got)
+	}
+
+	// Idle channels are closed after IdleTimeout.
+	c = binaryChannel(t, l.Addr().String())
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected idle channel closed")
+	}
+}
+
+func TestServerBinaryHeadLimits(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	hijacked := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, req *http.Request) {
+		c, bw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			hijacked <- err
+			return
+		}
+		defer c.Close()
+		_, err = bw.ReadByte()
+		hijacked <- err
+	})
+	s := &Server{Handler: mux, MaxHeaderBytes: 1024, ReadTimeout: 100 * time.Millisecond}
+	go s.Serve(l)
+	defer s.Close()
+
+	res := binaryGet(t, binaryChannel(t, l.Addr().String()), "/ok")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Date") == "" {
+		t.Errorf("Expected 200 with a Date, got %v, %v", res.Status, res.Header)
+	}
+
+	c := binaryChannel(t, l.Addr().String())
+	req, _ := http.NewRequest("GET", "http://frames/ok", nil)
+	req.Header.Set("X-Big", strings.Repeat("x", 2000))
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	res, err = readBinaryResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	if res.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected 431 for a large head, got %v", res.Status)
+	}
+
+	// A hijacked channel keeps ReadTimeout.
+	c = binaryChannel(t, l.Addr().String())
+	req, _ = http.NewRequest("GET", "http://frames/hijack", nil)
+	if err := writeBinaryRequest(c, req); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	if err := <-hijacked; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected hijacked read to time out, got %v", err)
+	}
+}
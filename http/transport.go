@@ -0,0 +1,72 @@
+package framesweb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+// dialContext opens a channel from d, giving up when ctx is done or
+// timeout, if positive, passes.
+func dialContext(ctx context.Context, d frames.ChannelDialer, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 && ctx.Done() == nil {
+		return d.Dial()
+	}
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := d.Dial()
+		ch <- result{c, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	var err error
+	select {
+	case r := <-ch:
+		return r.c, r.err
+	case <-expired:
+		err = timeoutError{"dial"}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// Don't leak a channel opened after we gave up.
+	go func() {
+		if r := <-ch; r.c != nil {
+			r.c.Close()
+		}
+	}()
+	return nil, err
+}
+
+// DialContext returns a function suitable for http.Transport's
+// DialContext that opens channels from d.  The network and address
+// are ignored; every connection is a channel of d's session.
+func DialContext(d frames.ChannelDialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialContext(ctx, d, 0)
+	}
+}
+
+// NewTransport returns an http.Transport making requests over
+// channels from d, keeping idle channels for reuse.
+func NewTransport(d frames.ChannelDialer) *http.Transport {
+	return &http.Transport{
+		DialContext:         DialContext(d),
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
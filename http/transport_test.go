@@ -0,0 +1,81 @@
+package framesweb
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dustin/frames"
+)
+
+func TestTransport(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trailer", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Sum")
+		io.WriteString(w, "body")
+		w.Header().Set("X-Sum", "42")
+	})
+	mux.HandleFunc("/close", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Connection", "close")
+		io.WriteString(w, "bye")
+	})
+	s := &Server{Handler: mux}
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	var m frames.Metrics
+	d, err := frames.NewClientWithOptions(c, frames.ClientOptions{Metrics: &m})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer d.Close()
+	tr := NewTransport(d)
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	for i := 0; i < 3; i++ {
+		res, err := hc.Get("http://frames/trailer")
+		if err != nil {
+			t.Fatalf("Error getting: %v", err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != "body" || res.Trailer.Get("X-Sum") != "42" {
+			t.Errorf("Expected body with trailer, got %q, %v", b, res.Trailer)
+		}
+	}
+	if n := m.Snapshot().ChannelsTotal; n != 1 {
+		t.Errorf("Expected a single channel reused, got %v", n)
+	}
+
+	// The server closing a channel is seen by the transport.
+	tr.CloseIdleConnections()
+	for i := 0; i < 2; i++ {
+		res, err := hc.Get("http://frames/close")
+		if err != nil {
+			t.Fatalf("Error getting: %v", err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	for m.Snapshot().ChannelsOpen != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := m.Snapshot().ChannelsTotal; n != 3 {
+		t.Errorf("Expected a channel per closed request, got %v", n)
+	}
+}
@@ -0,0 +1,42 @@
+package framesweb
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// upgraded reports whether res hands its channel over to another
+// protocol, as with 101 Switching Protocols or a successful CONNECT.
+func upgraded(req *http.Request, res *http.Response) bool {
+	return res.StatusCode == http.StatusSwitchingProtocols ||
+		(req.Method == http.MethodConnect && res.StatusCode/100 == 2)
+}
+
+// An upgradedBody is the body of an upgraded response: the channel
+// itself, in both directions.  Data the server sent along with the
+// response headers is read first.
+type upgradedBody struct {
+	b     *bufio.Reader
+	c     net.Conn
+	obs   Observer
+	req   *http.Request
+	start time.Time
+	n     int64
+}
+
+func (u *upgradedBody) Read(b []byte) (int, error) {
+	n, err := u.b.Read(b)
+	u.n += int64(n)
+	return n, err
+}
+
+func (u *upgradedBody) Write(b []byte) (int, error) {
+	return u.c.Write(b)
+}
+
+func (u *upgradedBody) Close() error {
+	u.obs.BodyClosed(u.req, u.n, time.Since(u.start))
+	return u.c.Close()
+}
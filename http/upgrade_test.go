@@ -0,0 +1,90 @@
+package framesweb
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// echoTunnel answers with status, hijacks the channel and echoes
+// everything sent on it, starting with a greeting.
+func echoTunnel(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		c, bw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer c.Close()
+		res := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1,
+			Header: http.Header{}}
+		if status == http.StatusSwitchingProtocols {
+			res.Header.Set("Connection", "Upgrade")
+			res.Header.Set("Upgrade", "echo")
+		}
+		res.Write(bw)
+		bw.WriteString("hi ")
+		bw.Flush()
+		io.Copy(c, bw)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	frt := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "CONNECT" {
+			echoTunnel(http.StatusOK)(w, req)
+			return
+		}
+		echoTunnel(http.StatusSwitchingProtocols)(w, req)
+	}))
+	frt.BodyTimeout = time.Millisecond
+
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		want int
+	}{
+		{"upgrade", func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://frames/echo", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "echo")
+			return req
+		}, http.StatusSwitchingProtocols},
+		{"connect", func() *http.Request {
+			req, _ := http.NewRequest("CONNECT", "http://frames:443", nil)
+			return req
+		}, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := frt.RoundTrip(test.req())
+			if err != nil {
+				t.Fatalf("Error round tripping: %v", err)
+			}
+			if res.StatusCode != test.want {
+				t.Fatalf("Expected %v, got %v", test.want, res.Status)
+			}
+			rw, ok := res.Body.(io.ReadWriteCloser)
+			if !ok {
+				t.Fatalf("Expected a ReadWriteCloser body, got %T", res.Body)
+			}
+			defer rw.Close()
+
+			// Outlive the body timeout, which no longer applies.
+			time.Sleep(10 * time.Millisecond)
+			if _, err := io.WriteString(rw, "there\n"); err != nil {
+				t.Fatalf("Error writing: %v", err)
+			}
+			line, err := bufio.NewReader(rw).ReadString('\n')
+			if err != nil || line != "hi there\n" {
+				t.Errorf("Expected echo, got %q, %v", line, err)
+			}
+		})
+	}
+}
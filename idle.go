@@ -0,0 +1,120 @@
+package frames
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// resetReason is the error a FrameClose from the server carries, if
+// any.
+func resetReason(pkt *FramePacket) error {
+	if pkt.Status == FrameSuccess {
+		return nil
+	}
+	if string(pkt.Data) == ErrIdleTimeout.Error() {
+		return ErrIdleTimeout
+	}
+	return frameError(*pkt)
+}
+
+func resetOr(reason, def error) error {
+	if reason != nil {
+		return reason
+	}
+	return def
+}
+
+func (c *channelStats) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+}
+
+func reapInterval(timeouts ...time.Duration) time.Duration {
+	var rv time.Duration
+	for _, t := range timeouts {
+		if t > 0 && (rv == 0 || t < rv) {
+			rv = t
+		}
+	}
+	return rv / 4
+}
+
+// reapLoop resets idle channels and closes the session once it's
+// been without channels too long.
+func (f *frameConnection) reapLoop(channelIdle, sessionIdle time.Duration) {
+	t := time.NewTicker(reapInterval(channelIdle, sessionIdle))
+	defer t.Stop()
+	emptySince := time.Now()
+	for {
+		var now time.Time
+		select {
+		case now = <-t.C:
+		case <-f.closeMarker:
+			return
+		}
+
+		var idle []*frameChannel
+		f.mu.Lock()
+		// Channels of clients that don't acknowledge closes may be
+		// closed, but still hold their IDs; they don't count.
+		open := 0
+		for _, ch := range f.channels {
+			if ch.isClosed() {
+				continue
+			}
+			open++
+			if channelIdle > 0 && ch.idle(now) >= channelIdle {
+				idle = append(idle, ch)
+			}
+		}
+		f.mu.Unlock()
+
+		for _, ch := range idle {
+			f.log.Info("resetting idle channel", "channel", ch.channel)
+			f.resetChannel(ch, ErrIdleTimeout)
+			open--
+		}
+
+		if open > 0 {
+			emptySince = time.Time{}
+			continue
+		}
+		if emptySince.IsZero() {
+			emptySince = now
+		}
+		if sessionIdle > 0 && now.Sub(emptySince) >= sessionIdle {
+			f.log.Info("closing idle session")
+			f.closeWith(ErrIdleTimeout)
+			return
+		}
+	}
+}
+
+// resetChannel closes ch on both ends, telling the client why.  A
+// client that doesn't acknowledge closes isn't told, so the channel
+// is only closed locally.
+func (f *frameConnection) resetChannel(ch *frameChannel, reason error) {
+	if !ch.closeAck {
+		ch.closeWith(reason)
+		return
+	}
+	f.mu.Lock()
+	if f.channels[ch.channel] != ch {
+		f.mu.Unlock()
+		return
+	}
+	delete(f.channels, ch.channel)
+	f.quarantine[ch.channel] = true
+	f.mu.Unlock()
+
+	ch.closeWith(reason)
+	select {
+	case f.egress <- &FramePacket{
+		Cmd:     FrameClose,
+		Status:  FrameError,
+		Channel: ch.channel,
+		Data:    []byte(reason.Error()),
+		rch:     make(chan error, 1),
+	}:
+	case <-f.closeMarker:
+	}
+}
@@ -0,0 +1,159 @@
+package frames
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestChannelIdleTimeout(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	addr, accepted := runServerWithOptions(t, ServerOptions{
+		ChannelIdleTimeout: 20 * time.Millisecond,
+	})
+	d := dialLimited(t, addr)
+
+	c, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	s := <-accepted
+
+	b := make([]byte, 10)
+	if _, err := c.Read(b); err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout reading on the client, got %v", err)
+	}
+	if _, err := c.Write(b); err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout writing on the client, got %v", err)
+	}
+	if _, err := s.Read(b); err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout reading on the server, got %v", err)
+	}
+	if _, err := s.Write(b); err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout writing on the server, got %v", err)
+	}
+	if n := d.GetInfo().ChannelsOpen; n != 0 {
+		t.Errorf("Expected no channels open on the client, got %v", n)
+	}
+	c.Close()
+
+	// The session is still usable.
+	c, err = d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing after reset: %v", err)
+	}
+	c.Close()
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	closed := make(chan error, 1)
+	addr, _ := runServerWithOptions(t, ServerOptions{
+		SessionIdleTimeout: 20 * time.Millisecond,
+		Hooks: Hooks{
+			SessionClosed: func(s SessionInfo, cause error) { closed <- cause },
+		},
+	})
+	d := dialLimited(t, addr)
+
+	// A session with a channel isn't idle.
+	c, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-closed:
+		t.Fatalf("Session closed with a channel open: %v", err)
+	default:
+	}
+	c.Close()
+
+	if err := <-closed; err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout closing the session, got %v", err)
+	}
+	<-d.(*frameClient).closeMarker
+}
+
+// A client that doesn't acknowledge closes keeps the IDs of channels
+// reset for idleness, but its session is still idle without them.
+func TestSessionIdleTimeoutWithoutCloseAck(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	defer c.Close()
+	closed := make(chan error, 1)
+	l, err := ListenWithOptions(s, ServerOptions{
+		ChannelIdleTimeout: 20 * time.Millisecond,
+		SessionIdleTimeout: 20 * time.Millisecond,
+		Logger:             slog.New(slog.DiscardHandler),
+		Hooks: Hooks{
+			SessionClosed: func(s SessionInfo, cause error) { closed <- cause },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	cli := newRawPeer(t, c)
+	cli.send(FramePacket{Cmd: FrameOpen})
+	cli.expect(FrameOpen, 1)
+	if _, err := l.Accept(); err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+
+	if err := <-closed; err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout closing the session, got %v", err)
+	}
+	if pkt, err := cli.dec.ReadPacket(); err == nil {
+		t.Errorf("Expected session closed, got %v", pkt)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	// Nobody reads the other end of the pipe.
+	c, s := net.Pipe()
+	defer s.Close()
+	d, err := NewClientWithOptions(c, ClientOptions{
+		WriteTimeout: 10 * time.Millisecond,
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer d.Close()
+
+	_, err = d.Dial()
+	var ne net.Error
+	if err == nil {
+		t.Fatalf("Expected dial to fail")
+	}
+	<-d.(*frameClient).closeMarker
+	if cause := d.(*frameClient).cause; !errors.As(cause, &ne) || !ne.Timeout() {
+		t.Errorf("Expected a timeout closing the session, got %v", cause)
+	}
+}
+
+func TestReapInterval(t *testing.T) {
+	t.Parallel()
+	if got := reapInterval(0, time.Second, 0, 100*time.Millisecond); got != 25*time.Millisecond {
+		t.Errorf("Expected 25ms, got %v", got)
+	}
+	if got := reapInterval(0); got != 0 {
+		t.Errorf("Expected 0, got %v", got)
+	}
+}
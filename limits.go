@@ -0,0 +1,198 @@
+package frames
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned (possibly wrapped) when a server
+// refuses a channel or session because of a resource limit.
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// Limits cap the resources a server will give its clients.  Channel
+// opens over a limit are refused with a FrameLimited status naming the
+// limit.  A zero value for any limit means unlimited.
+type Limits struct {
+	// ChannelsPerSession caps open channels in a session.
+	ChannelsPerSession int
+	// BufferedPerSession caps the bytes received in a session
+	// that its channels haven't yet read.  Opens are refused
+	// while it's exceeded.
+	//
+	// A session stops reading while any channel has a frame it
+	// hasn't taken, so each channel holds at most one frame
+	// (MaxFrameLen bytes) of unread data.  This effectively caps
+	// the number of channels with unread data at about
+	// BufferedPerSession/MaxFrameLen.
+	BufferedPerSession int
+	// OpensPerSecond caps the rate of channel opens in a session.
+	// Up to one second's worth may be opened in a burst.
+	OpensPerSecond float64
+	// SessionsPerListener and SessionsPerIP cap the sessions a
+	// ListenerListener serves at once, in total and from a single
+	// remote IP.  Connections over the limit never become
+	// sessions: the first open is refused and the connection is
+	// closed, or it's closed after a second if nothing is opened.
+	SessionsPerListener int
+	SessionsPerIP       int
+}
+
+func (l Limits) validate() error {
+	if l.ChannelsPerSession < 0 {
+		return invalidOption("Limits.ChannelsPerSession", l.ChannelsPerSession)
+	}
+	if l.BufferedPerSession < 0 {
+		return invalidOption("Limits.BufferedPerSession", l.BufferedPerSession)
+	}
+	if l.OpensPerSecond < 0 {
+		return invalidOption("Limits.OpensPerSecond", l.OpensPerSecond)
+	}
+	if l.SessionsPerListener < 0 {
+		return invalidOption("Limits.SessionsPerListener", l.SessionsPerListener)
+	}
+	if l.SessionsPerIP < 0 {
+		return invalidOption("Limits.SessionsPerIP", l.SessionsPerIP)
+	}
+	return nil
+}
+
+func limitError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrLimitExceeded, reason)
+}
+
+// rateLimiter is a token bucket refilled at rate per second, holding
+// at most a second's worth.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (r *rateLimiter) allow(now time.Time) bool {
+	if r == nil {
+		return true
+	}
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// overLimit returns the limit a new channel open would exceed, if
+// any.  It's only called from the read loop.
+func (f *frameConnection) overLimit() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if max := f.limits.ChannelsPerSession; max > 0 && len(f.channels) >= max {
+		return "channels per session"
+	}
+	if max := f.limits.BufferedPerSession; max > 0 {
+		buffered := 0
+		for _, ch := range f.channels {
+			buffered += ch.queued()
+		}
+		if buffered >= max {
+			return "bytes buffered per session"
+		}
+	}
+	if !f.opens.allow(time.Now()) {
+		return "opens per second"
+	}
+	return ""
+}
+
+// refuseOpen answers an open with a FrameLimited status.
+func (f *frameConnection) refuseOpen(reason string) {
+	err := limitError(reason)
+	f.log.Info("refused channel open", "reason", reason)
+	f.hooks.openRejected(f.session, err)
+	f.metrics.openRejected()
+	response := &FramePacket{
+		Cmd:    FrameOpen,
+		Status: FrameLimited,
+		Data:   []byte(reason),
+		rch:    make(chan error, 1),
+	}
+	select {
+	case f.egress <- response:
+	case <-f.closeMarker:
+	}
+}
+
+// How long a connection over a session limit has to send its first
+// open before it's closed.
+const refuseGrace = time.Second
+
+// refuseSession answers the first open on a connection over a session
+// limit with a FrameLimited status, then closes it.  No session is
+// established, so there are no hooks, metrics or debug entries.
+func refuseSession(c net.Conn, reason string, log *slog.Logger) {
+	defer c.Close()
+	log.Info("refused session", "remote", c.RemoteAddr().String(), "reason", reason)
+	c.SetDeadline(time.Now().Add(refuseGrace))
+	pkt, err := NewDecoder(c).ReadPacket()
+	if err != nil || pkt.Cmd != FrameOpen {
+		return
+	}
+	NewEncoder(c).WritePacket(&FramePacket{
+		Cmd:    FrameOpen,
+		Status: FrameLimited,
+		Data:   []byte(reason),
+	})
+}
+
+// sessionLimiter tracks sessions served by a listener.
+type sessionLimiter struct {
+	limits Limits
+	mu     sync.Mutex
+	total  int
+	perIP  map[string]int
+}
+
+// admit accounts for a new session from addr, returning the limit it
+// exceeds, if any, and a func to call when the session ends.
+func (s *sessionLimiter) admit(addr net.Addr) (string, func()) {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max := s.limits.SessionsPerListener; max > 0 && s.total >= max {
+		return "sessions per listener", func() {}
+	}
+	if max := s.limits.SessionsPerIP; max > 0 && s.perIP[ip] >= max {
+		return "sessions per IP", func() {}
+	}
+	s.total++
+	if s.perIP == nil {
+		s.perIP = map[string]int{}
+	}
+	s.perIP[ip]++
+	return "", func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.total--
+		if s.perIP[ip]--; s.perIP[ip] == 0 {
+			delete(s.perIP, ip)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package frames
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func runLimitedServer(t *testing.T, limits Limits) (string, chan net.Conn) {
+	return runServerWithOptions(t, ServerOptions{Limits: limits})
+}
+
+func runServerWithOptions(t *testing.T, opts ServerOptions) (string, chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	ll, err := ListenerListenerWithOptions(l, opts)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := ll.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+func dialLimited(t *testing.T, addr string) ChannelDialer {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	d, err := NewClientWithOptions(c, ClientOptions{Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func expectLimited(t *testing.T, d ChannelDialer, reason string) {
+	t.Helper()
+	c, err := d.Dial()
+	if err == nil {
+		c.Close()
+		t.Fatalf("Expected open to be refused for %v", reason)
+	}
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Expected limit error, got %v", err)
+	}
+	var fe frameError
+	if !errors.As(err, &fe) || string(fe.Data) != reason {
+		t.Errorf("Expected reason %q, got %v", reason, err)
+	}
+}
+
+func TestChannelLimit(t *testing.T) {
+	t.Parallel()
+	addr, _ := runLimitedServer(t, Limits{ChannelsPerSession: 2})
+	d := dialLimited(t, addr)
+
+	c1, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	if _, err := d.Dial(); err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	expectLimited(t, d, "channels per session")
+
+	c1.Close()
+	c, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing after a close: %v", err)
+	}
+	c.Close()
+}
+
+func TestOpenRateLimit(t *testing.T) {
+	t.Parallel()
+	addr, _ := runLimitedServer(t, Limits{OpensPerSecond: 2})
+	d := dialLimited(t, addr)
+	for i := 0; i < 2; i++ {
+		c, err := d.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		c.Close()
+	}
+	expectLimited(t, d, "opens per second")
+
+	r := newRateLimiter(2)
+	now := r.last
+	for i, exp := range []bool{true, true, false} {
+		if got := r.allow(now); got != exp {
+			t.Errorf("allow #%d = %v, want %v", i, got, exp)
+		}
+	}
+	if !r.allow(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Expected a token after half a second")
+	}
+	if r.allow(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Expected only one token after half a second")
+	}
+	if newRateLimiter(0) != nil || !(*rateLimiter)(nil).allow(now) {
+		t.Errorf("Expected an unlimited rate limiter to allow everything")
+	}
+}
+
+func TestBufferedLimit(t *testing.T) {
+	t.Parallel()
+	addr, accepted := runLimitedServer(t, Limits{BufferedPerSession: 5})
+	d := dialLimited(t, addr)
+
+	c, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	s := <-accepted
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(s, b); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	expectLimited(t, d, "bytes buffered per session")
+
+	b = make([]byte, 9)
+	if _, err := io.ReadFull(s, b); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	c2, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing after draining: %v", err)
+	}
+	c2.Close()
+}
+
+func TestSessionLimit(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	m := &Metrics{}
+	addr, _ := runServerWithOptions(t, ServerOptions{
+		Limits:  Limits{SessionsPerIP: 1},
+		Metrics: m,
+	})
+	d1 := dialLimited(t, addr)
+	c, err := d1.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	c.Close()
+
+	d2 := dialLimited(t, addr)
+	expectLimited(t, d2, "sessions per IP")
+	// The refused session is closed by the server.
+	<-d2.(*frameClient).closeMarker
+
+	// So is one that never opens anything.
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer raw.Close()
+	start := time.Now()
+	if _, err := raw.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF from refused connection, got %v", err)
+	}
+	if d := time.Since(start); d > 2*refuseGrace {
+		t.Errorf("Refused connection stayed open for %v", d)
+	}
+	if n := m.Snapshot().SessionsTotal; n != 1 {
+		t.Errorf("Expected refused sessions to go uncounted, got %v sessions", n)
+	}
+
+	// Once the first session ends, another may take its place.
+	d1.Close()
+	for i := 0; ; i++ {
+		d3 := dialLimited(t, addr)
+		c, err := d3.Dial()
+		if err == nil {
+			c.Close()
+			break
+		}
+		if i > 100 {
+			t.Fatalf("Never admitted a new session: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimitValidation(t *testing.T) {
+	t.Parallel()
+	for _, l := range []Limits{
+		{ChannelsPerSession: -1},
+		{BufferedPerSession: -1},
+		{OpensPerSecond: -1},
+		{SessionsPerListener: -1},
+		{SessionsPerIP: -1},
+	} {
+		if err := (ServerOptions{Limits: l}).validate(); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected invalid option for %+v, got %v", l, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package frames
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds (in seconds) of the open latency histogram buckets.
+var openLatencyBuckets = [...]float64{
+	.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// Metrics accumulates counters across any number of sessions.  Share
+// one by setting it in ClientOptions or ServerOptions.  A nil *Metrics
+// records nothing.
+type Metrics struct {
+	sessionsOpen   int64
+	sessionsTotal  uint64
+	channelsOpen   int64
+	channelsTotal  uint64
+	bytesRead      uint64
+	bytesWritten   uint64
+	framesRead     uint64
+	framesWritten  uint64
+	opensRejected  uint64
+	protocolErrors uint64
+	strayFrames    uint64
+
+	// One per bucket, plus one for +Inf.
+	latencyCounts [len(openLatencyBuckets) + 1]uint64
+	latencySum    int64
+}
+
+// MetricsSnapshot is a point-in-time copy of a Metrics.
+type MetricsSnapshot struct {
+	SessionsOpen   int64  `json:"sessions_open"`
+	SessionsTotal  uint64 `json:"sessions_total"`
+	ChannelsOpen   int64  `json:"channels_open"`
+	ChannelsTotal  uint64 `json:"channels_total"`
+	BytesRead      uint64 `json:"bytes_read"`
+	BytesWritten   uint64 `json:"bytes_written"`
+	FramesRead     uint64 `json:"frames_read"`
+	FramesWritten  uint64 `json:"frames_written"`
+	OpensRejected  uint64 `json:"opens_rejected"`
+	ProtocolErrors uint64 `json:"protocol_errors"`
+	// StrayFrames are frames dropped for arriving on closed or
+	// unknown channels.
+	StrayFrames uint64 `json:"stray_frames"`
+	// OpenLatency is the time from Dial to the server's response.
+	// It's only observed by client sessions.
+	OpenLatency HistogramSnapshot `json:"open_latency"`
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram.
+type HistogramSnapshot struct {
+	// Bounds are the bucket upper bounds, in seconds.
+	Bounds []float64 `json:"bounds"`
+	// Counts are the cumulative number of observations less than
+	// or equal to the corresponding bound.
+	Counts []uint64 `json:"counts"`
+	// Count is the total number of observations.
+	Count uint64 `json:"count"`
+	// Sum is the sum of all observations, in seconds.
+	Sum float64 `json:"sum"`
+}
+
+// Snapshot returns the current values of m.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	if m == nil {
+		return MetricsSnapshot{}
+	}
+	rv := MetricsSnapshot{
+		SessionsOpen:   atomic.LoadInt64(&m.sessionsOpen),
+		SessionsTotal:  atomic.LoadUint64(&m.sessionsTotal),
+		ChannelsOpen:   atomic.LoadInt64(&m.channelsOpen),
+		ChannelsTotal:  atomic.LoadUint64(&m.channelsTotal),
+		BytesRead:      atomic.LoadUint64(&m.bytesRead),
+		BytesWritten:   atomic.LoadUint64(&m.bytesWritten),
+		FramesRead:     atomic.LoadUint64(&m.framesRead),
+		FramesWritten:  atomic.LoadUint64(&m.framesWritten),
+		OpensRejected:  atomic.LoadUint64(&m.opensRejected),
+		ProtocolErrors: atomic.LoadUint64(&m.protocolErrors),
+		StrayFrames:    atomic.LoadUint64(&m.strayFrames),
+	}
+
+	h := HistogramSnapshot{
+		Bounds: append([]float64(nil), openLatencyBuckets[:]...),
+		Counts: make([]uint64, len(openLatencyBuckets)),
+		Sum:    time.Duration(atomic.LoadInt64(&m.latencySum)).Seconds(),
+	}
+	for i := range m.latencyCounts {
+		h.Count += atomic.LoadUint64(&m.latencyCounts[i])
+		if i < len(h.Counts) {
+			h.Counts[i] = h.Count
+		}
+	}
+	rv.OpenLatency = h
+
+	return rv
+}
+
+func (m *Metrics) sessionOpened() {
+	if m != nil {
+		atomic.AddInt64(&m.sessionsOpen, 1)
+		atomic.AddUint64(&m.sessionsTotal, 1)
+	}
+}
+
+func (m *Metrics) sessionClosed(cause error) {
+	if m != nil {
+		atomic.AddInt64(&m.sessionsOpen, -1)
+		if _, ok := cause.(*ProtocolError); ok {
+			atomic.AddUint64(&m.protocolErrors, 1)
+		}
+	}
+}
+
+func (m *Metrics) channelOpened() {
+	if m != nil {
+		atomic.AddInt64(&m.channelsOpen, 1)
+		atomic.AddUint64(&m.channelsTotal, 1)
+	}
+}
+
+func (m *Metrics) channelClosed() {
+	if m != nil {
+		atomic.AddInt64(&m.channelsOpen, -1)
+	}
+}
+
+func (m *Metrics) openRejected() {
+	if m != nil {
+		atomic.AddUint64(&m.opensRejected, 1)
+	}
+}
+
+func (m *Metrics) strayFrame() {
+	if m != nil {
+		atomic.AddUint64(&m.strayFrames, 1)
+	}
+}
+
+func (m *Metrics) frameRead(pkt *FramePacket) {
+	if m != nil {
+		atomic.AddUint64(&m.framesRead, 1)
+		atomic.AddUint64(&m.bytesRead, uint64(minPktLen+len(pkt.Data)))
+	}
+}
+
+func (m *Metrics) frameWritten(pkt *FramePacket) {
+	if m != nil {
+		atomic.AddUint64(&m.framesWritten, 1)
+		atomic.AddUint64(&m.bytesWritten, uint64(minPktLen+len(pkt.Data)))
+	}
+}
+
+func (m *Metrics) observeOpen(d time.Duration) {
+	if m == nil {
+		return
+	}
+	s := d.Seconds()
+	i := 0
+	for i < len(openLatencyBuckets) && s > openLatencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&m.latencyCounts[i], 1)
+	atomic.AddInt64(&m.latencySum, int64(d))
+}
@@ -0,0 +1,87 @@
+package frames
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	t.Parallel()
+
+	c, peer := net.Pipe()
+	var cm, sm Metrics
+
+	l, err := ListenWithOptions(peer, ServerOptions{Metrics: &sm})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	go func() {
+		for {
+			ch, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ch, ch)
+		}
+	}()
+
+	fc, err := NewClientWithOptions(c, ClientOptions{Metrics: &cm})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+
+	ch, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	ch.Write([]byte("hello"))
+	if _, err := io.ReadFull(ch, make([]byte, 5)); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+
+	// The writer counts a frame once it's written, which may be after
+	// the reply arrives.
+	for cm.Snapshot().FramesWritten < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	s := cm.Snapshot()
+	if s.SessionsOpen != 1 || s.ChannelsOpen != 1 || s.ChannelsTotal != 1 {
+		t.Errorf("Unexpected client session/channel counts: %+v", s)
+	}
+	// open + data out, open reply + data in
+	if s.FramesWritten != 2 || s.FramesRead != 2 {
+		t.Errorf("Unexpected client frame counts: %+v", s)
+	}
+	// Both opens carry the close ack offer.
+	want := uint64(2*minPktLen + len(closeAck) + 5)
+	if s.BytesWritten != want || s.BytesRead != want {
+		t.Errorf("Unexpected client byte counts: %+v", s)
+	}
+	if s.OpenLatency.Count != 1 || s.OpenLatency.Counts[len(s.OpenLatency.Counts)-1] != 1 {
+		t.Errorf("Unexpected open latency histogram: %+v", s.OpenLatency)
+	}
+
+	ch.Close()
+	fc.Close()
+	l.Close()
+
+	s = cm.Snapshot()
+	if s.SessionsOpen != 0 || s.ChannelsOpen != 0 || s.SessionsTotal != 1 {
+		t.Errorf("Unexpected client counts after close: %+v", s)
+	}
+	s = sm.Snapshot()
+	if s.SessionsTotal != 1 || s.ChannelsTotal != 1 || s.FramesRead < 2 {
+		t.Errorf("Unexpected server counts: %+v", s)
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.frameRead(&FramePacket{})
+	if s := nilMetrics.Snapshot(); s.FramesRead != 0 {
+		t.Errorf("Expected empty snapshot from nil metrics, got %+v", s)
+	}
+}
@@ -0,0 +1,233 @@
+package frames
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	defaultClientEgress    = 16
+	defaultClientOpenQueue = 16
+)
+
+// ClientOptions configure a client session created with
+// NewClientWithOptions.  The zero value gives the same behavior as
+// NewClient.
+type ClientOptions struct {
+	// EgressQueue is the number of outgoing packets that may be
+	// queued for the writer.  Default is 16.
+	EgressQueue int
+	// OpenQueue is the number of channel opens that may be
+	// awaiting a response from the server.  Default is 16.
+	OpenQueue int
+	// MaxFrameLen is the largest amount of data sent in a single
+	// frame.  Default (and maximum) is 32768.
+	MaxFrameLen int
+	// KeepAlive, if positive, enables TCP keepalives at the
+	// given interval on the underlying connection.
+	KeepAlive time.Duration
+	// WriteTimeout, if positive, limits how long a single write to
+	// the underlying connection may take before the session is
+	// torn down.
+	WriteTimeout time.Duration
+	// Logger receives diagnostic messages, annotated with the
+	// session's addresses.  Default is slog.Default().  Use
+	// slog.New(slog.DiscardHandler) to silence logging.
+	Logger *slog.Logger
+	// Hooks are called on session and channel events.  They run
+	// asynchronously, in order, so a slow hook never stalls
+	// traffic; see Hooks.
+	Hooks Hooks
+	// Metrics, if set, accumulates this session's counters.
+	Metrics *Metrics
+	// Protocols are offered, in order of preference, whenever a
+	// channel is opened.  The one the server chooses, if any, is
+	// the channel's ChannelInfo.Protocol.
+	Protocols []string
+}
+
+// ServerOptions configure a server session created with
+// ListenWithOptions or ListenerListenerWithOptions.  The zero value
+// gives the same behavior as Listen and ListenerListener.
+type ServerOptions struct {
+	// EgressQueue is the number of outgoing packets that may be
+	// queued for the writer.  Default is 0 (unbuffered).
+	EgressQueue int
+	// AcceptBacklog is the number of opened channels that may be
+	// waiting for Accept.  Default is 0 (unbuffered).
+	AcceptBacklog int
+	// MaxFrameLen is the largest amount of data sent in a single
+	// frame.  Default (and maximum) is 32768.
+	MaxFrameLen int
+	// KeepAlive, if positive, enables TCP keepalives at the
+	// given interval on the underlying connection.
+	KeepAlive time.Duration
+	// WriteTimeout, if positive, limits how long a single write to
+	// the underlying connection may take before the session is
+	// torn down.
+	WriteTimeout time.Duration
+	// Logger receives diagnostic messages, annotated with the
+	// session's addresses.  Default is slog.Default().  Use
+	// slog.New(slog.DiscardHandler) to silence logging.
+	Logger *slog.Logger
+	// Hooks are called on session and channel events.  They run
+	// asynchronously, in order, so a slow hook never stalls
+	// traffic; see Hooks.
+	Hooks Hooks
+	// Metrics, if set, accumulates this session's counters.
+	Metrics *Metrics
+	// Limits cap the resources clients may use.
+	Limits Limits
+	// ChannelIdleTimeout, if positive, resets channels that have
+	// neither read nor written for this long.  Both ends then see
+	// ErrIdleTimeout.
+	ChannelIdleTimeout time.Duration
+	// SessionIdleTimeout, if positive, closes sessions that have
+	// had no channels for this long.
+	SessionIdleTimeout time.Duration
+	// Protocols are those a client may choose for a channel when
+	// opening it.
+	Protocols []string
+	// SniffTimeout limits how long SniffListener waits for a new
+	// connection to send enough to tell whether it's frames.
+	// Connections sending nothing in time are closed.  Default is
+	// 10s.
+	SniffTimeout time.Duration
+}
+
+// ErrInvalidOption is returned when options fail validation.
+var ErrInvalidOption = errors.New("invalid option")
+
+func invalidOption(name string, v interface{}) error {
+	return fmt.Errorf("%w: %v = %v", ErrInvalidOption, name, v)
+}
+
+func validateCommon(egress, frameLen int, keepAlive, writeTimeout time.Duration) error {
+	if egress < 0 {
+		return invalidOption("EgressQueue", egress)
+	}
+	if frameLen < 0 || frameLen > maxWriteLen {
+		return invalidOption("MaxFrameLen", frameLen)
+	}
+	if keepAlive < 0 {
+		return invalidOption("KeepAlive", keepAlive)
+	}
+	if writeTimeout < 0 {
+		return invalidOption("WriteTimeout", writeTimeout)
+	}
+	return nil
+}
+
+func (o ClientOptions) validate() error {
+	if o.OpenQueue < 0 {
+		return invalidOption("OpenQueue", o.OpenQueue)
+	}
+	if err := validateProtocols(o.Protocols); err != nil {
+		return err
+	}
+	return validateCommon(o.EgressQueue, o.MaxFrameLen, o.KeepAlive, o.WriteTimeout)
+}
+
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.EgressQueue == 0 {
+		o.EgressQueue = defaultClientEgress
+	}
+	if o.OpenQueue == 0 {
+		o.OpenQueue = defaultClientOpenQueue
+	}
+	if o.MaxFrameLen == 0 {
+		o.MaxFrameLen = maxWriteLen
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	return o
+}
+
+func (o ServerOptions) validate() error {
+	if o.AcceptBacklog < 0 {
+		return invalidOption("AcceptBacklog", o.AcceptBacklog)
+	}
+	if o.ChannelIdleTimeout < 0 {
+		return invalidOption("ChannelIdleTimeout", o.ChannelIdleTimeout)
+	}
+	if o.SessionIdleTimeout < 0 {
+		return invalidOption("SessionIdleTimeout", o.SessionIdleTimeout)
+	}
+	if o.SniffTimeout < 0 {
+		return invalidOption("SniffTimeout", o.SniffTimeout)
+	}
+	if err := o.Limits.validate(); err != nil {
+		return err
+	}
+	if err := validateProtocols(o.Protocols); err != nil {
+		return err
+	}
+	return validateCommon(o.EgressQueue, o.MaxFrameLen, o.KeepAlive, o.WriteTimeout)
+}
+
+func (o ServerOptions) withDefaults() ServerOptions {
+	if o.MaxFrameLen == 0 {
+		o.MaxFrameLen = maxWriteLen
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	if o.SniffTimeout == 0 {
+		o.SniffTimeout = defaultSniffTimeout
+	}
+	return o
+}
+
+func sessionLogger(l *slog.Logger, c net.Conn) *slog.Logger {
+	return l.With("local", c.LocalAddr().String(), "remote", c.RemoteAddr().String())
+}
+
+func pktAttrs(pkt *FramePacket) slog.Attr {
+	return slog.Group("pkt",
+		"cmd", pkt.Cmd.String(),
+		"channel", pkt.Channel,
+		"status", pkt.Status.String(),
+		"len", len(pkt.Data))
+}
+
+// closeLevel is the level for logging an error seen on a session
+// that may have been closed locally.
+func closeLevel(closeMarker chan bool) slog.Level {
+	select {
+	case <-closeMarker:
+		return slog.LevelDebug
+	default:
+	}
+	return slog.LevelWarn
+}
+
+// setWriteDeadline arms the write timeout, if any, for the next
+// write to c.
+func setWriteDeadline(c net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+type keepAliver interface {
+	SetKeepAlive(bool) error
+	SetKeepAlivePeriod(time.Duration) error
+}
+
+func setKeepAlive(c net.Conn, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	ka, ok := c.(keepAliver)
+	if !ok {
+		return nil
+	}
+	if err := ka.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return ka.SetKeepAlivePeriod(d)
+}
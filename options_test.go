@@ -0,0 +1,164 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOptionValidation(t *testing.T) {
+	t.Parallel()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	clientTests := []ClientOptions{
+		{EgressQueue: -1},
+		{OpenQueue: -1},
+		{MaxFrameLen: -1},
+		{MaxFrameLen: maxWriteLen + 1},
+		{KeepAlive: -time.Second},
+		{WriteTimeout: -time.Second},
+	}
+	for _, opts := range clientTests {
+		if _, err := NewClientWithOptions(c1, opts); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected invalid option for %+v, got %v", opts, err)
+		}
+	}
+
+	serverTests := []ServerOptions{
+		{EgressQueue: -1},
+		{AcceptBacklog: -1},
+		{MaxFrameLen: maxWriteLen + 1},
+		{KeepAlive: -time.Second},
+		{WriteTimeout: -time.Second},
+		{ChannelIdleTimeout: -time.Second},
+		{SessionIdleTimeout: -time.Second},
+		{SniffTimeout: -time.Second},
+	}
+	for _, opts := range serverTests {
+		if _, err := ListenWithOptions(c2, opts); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected invalid option for %+v, got %v", opts, err)
+		}
+		if _, err := ListenerListenerWithOptions(nil, opts); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected invalid option for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestEndToEndWithOptions(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	t.Parallel()
+
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	l, err := ListenerListenerWithOptions(tl, ServerOptions{
+		EgressQueue:   4,
+		AcceptBacklog: 2,
+		MaxFrameLen:   7,
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	c1, err := net.Dial("tcp", tl.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	fc, err := NewClientWithOptions(c1, ClientOptions{
+		EgressQueue: 1,
+		OpenQueue:   1,
+		MaxFrameLen: 3,
+		KeepAlive:   time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+
+	c, err := fc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+
+	msg := []byte("hello, small frames")
+	if _, err := c.Write(msg); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Errorf("Expected %q, got %q", msg, got)
+	}
+	c.Close()
+
+	if err := fc.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(b)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestStructuredLogging(t *testing.T) {
+	t.Parallel()
+	c, peer := net.Pipe()
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	fcd, err := NewClientWithOptions(c, ClientOptions{Logger: logger})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	fc := fcd.(*frameClient)
+
+	WritePacket(peer, &FramePacket{Cmd: FrameData, Channel: 9, Data: []byte("x")})
+	peer.Close()
+	waitClosed(t, fc.closeMarker)
+
+	got := buf.String()
+	for _, want := range []string{`"msg":"data on non-existent channel"`,
+		`"remote":"pipe"`, `"channel":9`, `"cmd":"FrameData"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %s in log output:\n%s", want, got)
+		}
+	}
+}
@@ -5,6 +5,21 @@ import (
 	"fmt"
 )
 
+// A ProtocolError describes a violation of the frames protocol by a
+// peer.  Sessions are torn down with a ProtocolError as their cause
+// when one occurs.
+type ProtocolError struct {
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return "frames: protocol violation: " + e.Reason
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return &ProtocolError{fmt.Sprintf(format, args...)}
+}
+
 // FrameCmd is the type of command on a frames stream.
 type FrameCmd uint8
 
@@ -25,6 +40,9 @@ const (
 	FrameSuccess = FrameStatus(iota)
 	// FrameError is the status indicating a failed command.
 	FrameError
+	// FrameLimited is the status indicating a command was refused
+	// because of a resource limit.  The data names the limit.
+	FrameLimited
 )
 
 const minPktLen = 6
@@ -87,20 +105,45 @@ func (f frameError) Error() string {
 	return fmt.Sprintf("status=%v, data=%s", f.Status, f.Data)
 }
 
-// PacketFromHeader constructs a packet from the given header.
+// Is reports refusals for resource limits as ErrLimitExceeded.
+func (f frameError) Is(target error) bool {
+	return target == ErrLimitExceeded && f.Status == FrameLimited
+}
+
+// PacketFromHeader constructs a packet from the given header.  A
+// header that's too short gives the zero packet, and a data length
+// over the maximum is clamped to it.
+//
+// Deprecated: PacketFromHeader can't report a malformed header; use
+// DecodeHeader.
 func PacketFromHeader(hdr []byte) FramePacket {
+	pkt, err := DecodeHeader(hdr)
+	if err != nil && len(hdr) >= minPktLen {
+		var clamped [minPktLen]byte
+		copy(clamped[:], hdr)
+		binary.BigEndian.PutUint16(clamped[:], maxWriteLen)
+		pkt, _ = DecodeHeader(clamped[:])
+	}
+	return pkt
+}
+
+// DecodeHeader constructs a packet, with room for its data, from the
+// given header.  A malformed header gives a *ProtocolError.
+func DecodeHeader(hdr []byte) (FramePacket, error) {
 	if len(hdr) < minPktLen {
-		panic("Too short")
+		return FramePacket{}, protocolErrorf("header too short (%d bytes)", len(hdr))
 	}
 	dlen := binary.BigEndian.Uint16(hdr)
 	if dlen > maxWriteLen {
-		panic("data length exceeds max data len")
+		return FramePacket{}, protocolErrorf("data length %d exceeds max data len %d",
+			dlen, maxWriteLen)
 	}
 	return FramePacket{
 		Cmd:     FrameCmd(hdr[4]),
+		Status:  FrameStatus(hdr[5]),
 		Channel: binary.BigEndian.Uint16(hdr[2:]),
 		Data:    make([]byte, dlen),
-	}
+	}, nil
 }
 
 func (c FrameCmd) String() string {
@@ -121,6 +164,8 @@ func (c FrameStatus) String() string {
 		return "Success"
 	case FrameError:
 		return "Error"
+	case FrameLimited:
+		return "Limited"
 	}
 	return fmt.Sprintf("{FrameStatus 0x%x}", int(c))
 }
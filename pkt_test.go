@@ -39,6 +39,13 @@ func TestErrorStringing(t *testing.T) {
 		t.Errorf("Wanted %v, got %v", want, got)
 	}
 
+	e = frameError{Status: FrameLimited, Data: []byte("channels per session")}
+	got = e.Error()
+	want = `status=Limited, data=channels per session`
+	if got != want {
+		t.Errorf("Wanted %v, got %v", want, got)
+	}
+
 	e = frameError{Status: 11, Data: []byte("broken and unknown")}
 	got = e.Error()
 	want = `status={FrameStatus 0xb}, data=broken and unknown`
@@ -56,6 +63,29 @@ func TestBadFrameString(t *testing.T) {
 	}
 }
 
+func TestDecodeHeaderErrors(t *testing.T) {
+	tests := [][]byte{
+		{0, 0, 0},
+		{0x80, 1, 0, 1, 2, 0},
+	}
+	for _, test := range tests {
+		_, err := DecodeHeader(test)
+		if _, ok := err.(*ProtocolError); !ok {
+			t.Errorf("Expected protocol error decoding %v, got %v", test, err)
+		}
+	}
+}
+
+func TestPacketFromHeaderMalformed(t *testing.T) {
+	if pkt := PacketFromHeader([]byte{0, 0, 0}); !reflect.DeepEqual(pkt, FramePacket{}) {
+		t.Errorf("Expected zero packet from a short header, got %v", pkt)
+	}
+	pkt := PacketFromHeader([]byte{0x80, 1, 0, 1, 2, 0})
+	if len(pkt.Data) != maxWriteLen || pkt.Channel != 1 || pkt.Cmd != FrameData {
+		t.Errorf("Expected clamped data packet, got %v", pkt)
+	}
+}
+
 func benchEncoding(b *testing.B, size int) {
 	pkt := FramePacket{
 		Cmd:     FrameData,
@@ -0,0 +1,73 @@
+package frames
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Channel protocols are negotiated at open: a client offers its
+// Protocols, comma separated, as the data of a FrameOpen, and the
+// server answers with the first of them it supports, if any.  Peers
+// that don't negotiate ignore the data.
+
+// closeAck is offered by clients that acknowledge FrameClose from
+// the server, and echoed by servers that acknowledge FrameClose from
+// the client.  Unless both sides offer it for a channel, the server
+// never sends FrameClose for it.
+const closeAck = capabilityPrefix + "close-ack"
+
+// Offered names with this prefix are capabilities, not protocols.
+const capabilityPrefix = "frames:"
+
+func validateProtocols(protos []string) error {
+	for _, p := range protos {
+		if p == "" || strings.HasPrefix(p, capabilityPrefix) || strings.Contains(p, ",") {
+			return invalidOption("Protocols", protos)
+		}
+	}
+	if len(offerProtocols(protos)) > maxWriteLen {
+		return invalidOption("Protocols", protos)
+	}
+	return nil
+}
+
+func offerProtocols(protos []string) []byte {
+	return []byte(strings.Join(append(protos[:len(protos):len(protos)], closeAck), ","))
+}
+
+// answerOffer is the data of an open response choosing proto.
+func answerOffer(proto string, ack bool) []byte {
+	var rv []string
+	if proto != "" {
+		rv = append(rv, proto)
+	}
+	if ack {
+		rv = append(rv, closeAck)
+	}
+	return []byte(strings.Join(rv, ","))
+}
+
+// offersCloseAck reports whether open data includes closeAck.
+func offersCloseAck(data []byte) bool {
+	for _, p := range bytes.Split(data, []byte(",")) {
+		if string(p) == closeAck {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the first protocol in offer that's supported.
+func negotiate(offer []byte, supported []string) string {
+	if len(offer) == 0 {
+		return ""
+	}
+	for _, p := range bytes.Split(offer, []byte(",")) {
+		for _, s := range supported {
+			if string(p) == s {
+				return s
+			}
+		}
+	}
+	return ""
+}
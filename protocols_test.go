@@ -0,0 +1,65 @@
+package frames
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		offer, supported []string
+		want             string
+	}{
+		{"preferred", []string{"a", "c", "b"}, []string{"b", "c"}, "c"},
+		{"no offer", nil, []string{"b"}, ""},
+		{"unsupported", []string{"a"}, []string{"b"}, ""},
+		{"old server", []string{"a"}, nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := net.Pipe()
+			l, err := ListenWithOptions(s, ServerOptions{
+				Logger:    discardLogger,
+				Protocols: test.supported,
+			})
+			if err != nil {
+				t.Fatalf("Error listening: %v", err)
+			}
+			defer l.Close()
+			d, err := NewClientWithOptions(c, ClientOptions{
+				Logger:    discardLogger,
+				Protocols: test.offer,
+			})
+			if err != nil {
+				t.Fatalf("Error creating client: %v", err)
+			}
+			defer d.Close()
+
+			cc, err := d.Dial()
+			if err != nil {
+				t.Fatalf("Error dialing: %v", err)
+			}
+			sc, err := l.Accept()
+			if err != nil {
+				t.Fatalf("Error accepting: %v", err)
+			}
+			for _, ch := range []net.Conn{cc, sc} {
+				if ci, _ := ChannelInfoOf(ch); ci.Protocol != test.want {
+					t.Errorf("Expected protocol %q on %v, got %q", test.want, ch, ci.Protocol)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidProtocols(t *testing.T) {
+	for _, protos := range [][]string{{""}, {"a,b"}, {closeAck}} {
+		_, err := NewClientWithOptions(nil, ClientOptions{Protocols: protos})
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected invalid option for %q, got %v", protos, err)
+		}
+	}
+}
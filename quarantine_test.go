@@ -0,0 +1,291 @@
+package frames
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawPeer speaks the protocol by hand on one end of a pipe.
+type rawPeer struct {
+	t   *testing.T
+	c   net.Conn
+	dec *Decoder
+	enc *Encoder
+}
+
+func newRawPeer(t *testing.T, c net.Conn) *rawPeer {
+	return &rawPeer{t, c, NewDecoder(c), NewEncoder(c)}
+}
+
+func (p *rawPeer) send(pkt FramePacket) {
+	p.t.Helper()
+	if err := p.enc.WritePacket(&pkt); err != nil {
+		p.t.Fatalf("Error sending %v: %v", pkt, err)
+	}
+}
+
+func (p *rawPeer) expect(cmd FrameCmd, channel uint16) *FramePacket {
+	p.t.Helper()
+	pkt, err := p.dec.ReadPacket()
+	if err != nil {
+		p.t.Fatalf("Error reading: %v", err)
+	}
+	if pkt.Cmd != cmd || pkt.Channel != channel {
+		p.t.Fatalf("Expected %v on channel %d, got %v", cmd, channel, pkt)
+	}
+	return pkt
+}
+
+// ackOffer is open data from a peer that acknowledges closes.
+var ackOffer = []byte(closeAck)
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for i := 0; !f(); i++ {
+		if i > 1000 {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClientQuarantine(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	var m Metrics
+	d, err := NewClientWithOptions(c, ClientOptions{
+		Metrics: &m,
+		Logger:  slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer d.Close()
+	fc := d.(*frameClient)
+	srv := newRawPeer(t, s)
+
+	quarantined := func(id uint16) bool {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		return fc.quarantine[id]
+	}
+
+	opened := make(chan net.Conn)
+	go func() {
+		ch, err := d.Dial()
+		if err != nil {
+			t.Errorf("Error dialing: %v", err)
+		}
+		opened <- ch
+	}()
+	srv.expect(FrameOpen, 0)
+	srv.send(FramePacket{Cmd: FrameOpen, Channel: 5, Data: ackOffer})
+	ch := <-opened
+
+	// Client close quarantines the ID until acknowledged.
+	ch.Close()
+	srv.expect(FrameClose, 5)
+	if !quarantined(5) {
+		t.Fatalf("Expected channel 5 to be quarantined")
+	}
+	srv.send(FramePacket{Cmd: FrameData, Channel: 5, Data: []byte("late")})
+	srv.send(FramePacket{Cmd: FrameClose, Channel: 5})
+	waitFor(t, "ack", func() bool { return !quarantined(5) })
+	if n := m.Snapshot().StrayFrames; n != 1 {
+		t.Errorf("Expected 1 stray frame, got %v", n)
+	}
+
+	// Server close is acknowledged by the client.
+	go func() {
+		ch, err := d.Dial()
+		if err != nil {
+			t.Errorf("Error dialing: %v", err)
+		}
+		opened <- ch
+	}()
+	srv.expect(FrameOpen, 0)
+	srv.send(FramePacket{Cmd: FrameOpen, Channel: 5, Data: ackOffer})
+	ch = <-opened
+	srv.send(FramePacket{Cmd: FrameClose, Status: FrameError, Channel: 5,
+		Data: []byte(ErrIdleTimeout.Error())})
+	srv.expect(FrameClose, 5)
+	if _, err := ch.Read(make([]byte, 1)); err != ErrIdleTimeout {
+		t.Errorf("Expected idle timeout, got %v", err)
+	}
+	// Closing it locally afterwards sends nothing more.
+	ch.Close()
+	if quarantined(5) {
+		t.Errorf("Expected channel 5 released")
+	}
+}
+
+func TestServerQuarantine(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	var m Metrics
+	l, err := ListenWithOptions(s, ServerOptions{
+		Metrics:       &m,
+		AcceptBacklog: 10,
+		Logger:        slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	f := l.(*frameConnection)
+	cli := newRawPeer(t, c)
+
+	cli.send(FramePacket{Cmd: FrameOpen, Data: ackOffer})
+	id := cli.expect(FrameOpen, 1).Channel
+
+	// A client close is acknowledged.
+	cli.send(FramePacket{Cmd: FrameClose, Channel: id})
+	cli.expect(FrameClose, id)
+
+	cli.send(FramePacket{Cmd: FrameOpen, Data: ackOffer})
+	id = cli.expect(FrameOpen, 2).Channel
+
+	// A server reset quarantines the ID until the client acks.
+	f.mu.Lock()
+	ch := f.channels[id]
+	f.mu.Unlock()
+	go f.resetChannel(ch, ErrIdleTimeout)
+	cli.expect(FrameClose, id)
+
+	f.mu.Lock()
+	f.lastChid = id - 1
+	f.mu.Unlock()
+	next, err := f.nextID()
+	if err != nil || next == id {
+		t.Errorf("Expected quarantined ID %d skipped, got %v, %v", id, next, err)
+	}
+
+	// Closing a channel on the server tells the client.
+	cli.send(FramePacket{Cmd: FrameOpen, Data: ackOffer})
+	other := cli.expect(FrameOpen, next+1).Channel
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+	for sc.(*frameChannel).channel != other {
+		if sc, err = l.Accept(); err != nil {
+			t.Fatalf("Error accepting: %v", err)
+		}
+	}
+	go sc.Close()
+	cli.expect(FrameClose, other)
+	cli.send(FramePacket{Cmd: FrameClose, Channel: other})
+
+	cli.send(FramePacket{Cmd: FrameData, Channel: id, Data: []byte("late")})
+	cli.send(FramePacket{Cmd: FrameClose, Channel: id})
+	waitFor(t, "ack", func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return !f.quarantine[id]
+	})
+	if n := m.Snapshot().StrayFrames; n != 1 {
+		t.Errorf("Expected 1 stray frame, got %v", n)
+	}
+}
+
+// Writes on a closed server channel must not reach a new channel
+// that reused its ID.
+func TestServerWriteAfterClose(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	l, err := ListenWithOptions(s, ServerOptions{
+		AcceptBacklog: 10,
+		Logger:        slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	f := l.(*frameConnection)
+	cli := newRawPeer(t, c)
+
+	cli.send(FramePacket{Cmd: FrameOpen, Data: ackOffer})
+	id := cli.expect(FrameOpen, 1).Channel
+	old, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+	go old.Close()
+	cli.expect(FrameClose, id)
+	cli.send(FramePacket{Cmd: FrameClose, Channel: id})
+	waitFor(t, "ack", func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return !f.quarantine[id]
+	})
+
+	f.mu.Lock()
+	f.lastChid = id - 1
+	f.mu.Unlock()
+	cli.send(FramePacket{Cmd: FrameOpen, Data: ackOffer})
+	cli.expect(FrameOpen, id)
+	cur, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+
+	if _, err := old.Write([]byte("stale")); err != errClosedWriteCh {
+		t.Errorf("Expected write on closed channel to fail, got %v", err)
+	}
+	go cur.Write([]byte("fresh"))
+	if pkt := cli.expect(FrameData, id); string(pkt.Data) != "fresh" {
+		t.Errorf("Expected fresh data on reused channel, got %q", pkt.Data)
+	}
+}
+
+// Clients that don't offer close acknowledgement never see a
+// FrameClose from the server, nor get their closes acknowledged.
+func TestServerWithoutCloseAck(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	c, s := net.Pipe()
+	l, err := ListenWithOptions(s, ServerOptions{
+		AcceptBacklog: 10,
+		Logger:        slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	f := l.(*frameConnection)
+	cli := newRawPeer(t, c)
+
+	cli.send(FramePacket{Cmd: FrameOpen})
+	if pkt := cli.expect(FrameOpen, 1); len(pkt.Data) != 0 {
+		t.Errorf("Expected no close ack offered, got %q", pkt.Data)
+	}
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+	sc.Close()
+	f.resetChannel(sc.(*frameChannel), ErrIdleTimeout)
+	cli.send(FramePacket{Cmd: FrameClose, Channel: 1})
+
+	// The next thing the client sees is its next open's response.
+	cli.send(FramePacket{Cmd: FrameOpen})
+	cli.expect(FrameOpen, 2)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.quarantine) != 0 {
+		t.Errorf("Expected nothing quarantined, got %v", f.quarantine)
+	}
+}
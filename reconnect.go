@@ -0,0 +1,282 @@
+package frames
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of a reconnecting client's session.
+type ConnState int
+
+const (
+	// StateConnecting means a session is being dialed.
+	StateConnecting = ConnState(iota)
+	// StateConnected means a session is established.
+	StateConnected
+	// StateDisconnected means the session was lost.  Reconnection
+	// follows after a backoff.
+	StateDisconnected
+	// StateClosed means the client was closed.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("{ConnState %d}", int(s))
+}
+
+// ReconnectOptions configure a client created with
+// NewReconnectingClient.
+type ReconnectOptions struct {
+	// Client configures each session.
+	Client ClientOptions
+	// MinRedial and MaxRedial bound the exponential backoff between
+	// connection attempts.  Defaults are 100ms and 30s.
+	MinRedial time.Duration
+	MaxRedial time.Duration
+	// MinUptime is how long a session must stay up before the
+	// backoff is reset.  Sessions dying sooner are redialed after
+	// the current backoff.  Default is 5s.
+	MinUptime time.Duration
+	// FailFast makes Dial return ErrNoSessions immediately while
+	// there's no session, rather than waiting for one.
+	FailFast bool
+	// DialWait limits how long Dial waits for a session.  Zero
+	// waits until the client is closed.
+	DialWait time.Duration
+	// OnStateChange, if not nil, is called on every state change,
+	// with the error that caused it, if any.  Calls are made in
+	// order on a separate goroutine.
+	OnStateChange func(s ConnState, err error)
+}
+
+func (o ReconnectOptions) validate() error {
+	if o.MinRedial < 0 {
+		return invalidOption("MinRedial", o.MinRedial)
+	}
+	if o.MaxRedial < 0 || (o.MaxRedial > 0 && o.MaxRedial < o.MinRedial) {
+		return invalidOption("MaxRedial", o.MaxRedial)
+	}
+	if o.MinUptime < 0 {
+		return invalidOption("MinUptime", o.MinUptime)
+	}
+	if o.DialWait < 0 {
+		return invalidOption("DialWait", o.DialWait)
+	}
+	return o.Client.validate()
+}
+
+func (o ReconnectOptions) withDefaults() ReconnectOptions {
+	if o.MinRedial == 0 {
+		o.MinRedial = 100 * time.Millisecond
+	}
+	if o.MaxRedial == 0 {
+		o.MaxRedial = 30 * time.Second
+	}
+	if o.MinUptime == 0 {
+		o.MinUptime = defaultMinUptime
+	}
+	return o
+}
+
+type reconnectingClient struct {
+	dial        func(context.Context) (net.Conn, error)
+	opts        ReconnectOptions
+	ctx         context.Context
+	cancel      context.CancelFunc
+	notify      hookRunner
+	mu          sync.Mutex
+	fc          *frameClient
+	ready       chan bool // closed when fc is set
+	retired     Info
+	closeMarker chan bool
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewReconnectingClient returns a ChannelDialer over a session on a
+// connection from dial, which is replaced whenever it dies.
+//
+// The first connection is made in the background, so the only errors
+// returned are for invalid options.
+func NewReconnectingClient(dial func(context.Context) (net.Conn, error),
+	opts ReconnectOptions) (ChannelDialer, error) {
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := &reconnectingClient{
+		dial:        dial,
+		opts:        opts,
+		ctx:         ctx,
+		cancel:      cancel,
+		ready:       make(chan bool),
+		closeMarker: make(chan bool),
+	}
+	rc.wg.Add(1)
+	go rc.run()
+	return rc, nil
+}
+
+func (rc *reconnectingClient) setState(s ConnState, err error) {
+	if f := rc.opts.OnStateChange; f != nil {
+		rc.notify.run(func() { f(s, err) })
+	}
+}
+
+func (rc *reconnectingClient) connect() (*frameClient, error) {
+	c, err := rc.dial(rc.ctx)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewClientWithOptions(c, rc.opts.Client)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return d.(*frameClient), nil
+}
+
+// lost forgets fc if it's the current session.
+func (rc *reconnectingClient) lost(fc *frameClient) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.fc != fc {
+		return
+	}
+	rc.fc = nil
+	rc.ready = make(chan bool)
+	i := fc.GetInfo()
+	rc.retired.BytesRead += i.BytesRead
+	rc.retired.BytesWritten += i.BytesWritten
+}
+
+func (rc *reconnectingClient) run() {
+	defer rc.wg.Done()
+	delay := rc.opts.MinRedial
+	for {
+		rc.setState(StateConnecting, nil)
+		fc, err := rc.connect()
+		if err != nil {
+			rc.setState(StateDisconnected, err)
+			if !sleepBackoff(&delay, rc.opts.MaxRedial, rc.closeMarker) {
+				return
+			}
+			continue
+		}
+
+		rc.mu.Lock()
+		select {
+		case <-rc.closeMarker:
+			rc.mu.Unlock()
+			fc.Close()
+			return
+		default:
+		}
+		rc.fc = fc
+		close(rc.ready)
+		rc.mu.Unlock()
+		rc.setState(StateConnected, nil)
+		up := time.Now()
+
+		select {
+		case <-fc.closeMarker:
+		case <-rc.closeMarker:
+			return
+		}
+		rc.lost(fc)
+		rc.setState(StateDisconnected, fc.closedErr(ErrSessionClosed))
+
+		if time.Since(up) >= rc.opts.MinUptime {
+			delay = rc.opts.MinRedial
+		} else if !sleepBackoff(&delay, rc.opts.MaxRedial, rc.closeMarker) {
+			return
+		}
+	}
+}
+
+func (rc *reconnectingClient) Dial() (net.Conn, error) {
+	var timeout <-chan time.Time
+	if rc.opts.DialWait > 0 {
+		t := time.NewTimer(rc.opts.DialWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for {
+		select {
+		case <-rc.closeMarker:
+			return nil, ErrSessionClosed
+		default:
+		}
+
+		rc.mu.Lock()
+		fc, ready := rc.fc, rc.ready
+		rc.mu.Unlock()
+
+		if fc != nil {
+			c, err := fc.Dial()
+			if err == nil || !fc.closed() {
+				return c, err
+			}
+			// The session died under us; wait for the next.
+			rc.lost(fc)
+			continue
+		}
+
+		if rc.opts.FailFast {
+			return nil, ErrNoSessions
+		}
+		select {
+		case <-ready:
+		case <-rc.closeMarker:
+			return nil, ErrSessionClosed
+		case <-timeout:
+			return nil, ErrNoSessions
+		}
+	}
+}
+
+func (rc *reconnectingClient) GetInfo() Info {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rv := rc.retired
+	if rc.fc != nil {
+		i := rc.fc.GetInfo()
+		rv.BytesRead += i.BytesRead
+		rv.BytesWritten += i.BytesWritten
+		rv.ChannelsOpen += i.ChannelsOpen
+	}
+	return rv
+}
+
+func (rc *reconnectingClient) Close() error {
+	rc.closeOnce.Do(func() {
+		close(rc.closeMarker)
+		rc.cancel()
+		rc.mu.Lock()
+		fc := rc.fc
+		rc.mu.Unlock()
+		if fc != nil {
+			fc.Close()
+		}
+		rc.wg.Wait()
+		rc.setState(StateClosed, nil)
+	})
+	return nil
+}
@@ -0,0 +1,137 @@
+package frames
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type stateChange struct {
+	s   ConnState
+	err error
+}
+
+func expectState(t *testing.T, ch chan stateChange, want ConnState) stateChange {
+	t.Helper()
+	select {
+	case sc := <-ch:
+		if sc.s != want {
+			t.Fatalf("Expected state %v, got %v (%v)", want, sc.s, sc.err)
+		}
+		return sc
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for state %v", want)
+	}
+	panic("unreachable")
+}
+
+func TestReconnectingClient(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc := runTestEchoServer(t)
+	defer tc.l.Close()
+
+	var d net.Dialer
+	states := make(chan stateChange, 10)
+	rd, err := NewReconnectingClient(func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", tc.addr)
+	}, ReconnectOptions{
+		MinRedial: time.Millisecond,
+		OnStateChange: func(s ConnState, err error) {
+			states <- stateChange{s, err}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	rc := rd.(*reconnectingClient)
+
+	c, err := rc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	expectState(t, states, StateConnecting)
+	expectState(t, states, StateConnected)
+
+	rc.mu.Lock()
+	fc := rc.fc
+	rc.mu.Unlock()
+	fc.c.Close()
+
+	if sc := expectState(t, states, StateDisconnected); sc.err == nil {
+		t.Errorf("Expected an error with disconnection")
+	}
+	expectState(t, states, StateConnecting)
+	expectState(t, states, StateConnected)
+
+	if _, err := c.Write([]byte("hi")); err == nil {
+		t.Errorf("Expected error writing to a channel on a dead session")
+	}
+	c, err = rc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing after reconnect: %v", err)
+	}
+	c.Close()
+
+	rc.Close()
+	expectState(t, states, StateClosed)
+	if _, err := rc.Dial(); err != ErrSessionClosed {
+		t.Errorf("Expected closed error after close, got %v", err)
+	}
+}
+
+func TestReconnectingClientWaits(t *testing.T) {
+	t.Parallel()
+	errNope := errors.New("nope")
+	dial := func(context.Context) (net.Conn, error) {
+		return nil, errNope
+	}
+
+	rd, err := NewReconnectingClient(dial, ReconnectOptions{FailFast: true})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	if _, err := rd.Dial(); err != ErrNoSessions {
+		t.Errorf("Expected no sessions failing fast, got %v", err)
+	}
+	rd.Close()
+
+	rd, err = NewReconnectingClient(dial, ReconnectOptions{DialWait: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	start := time.Now()
+	if _, err := rd.Dial(); err != ErrNoSessions {
+		t.Errorf("Expected no sessions after waiting, got %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Errorf("Dial didn't wait")
+	}
+	rd.Close()
+
+	_, err = NewReconnectingClient(dial, ReconnectOptions{DialWait: -1})
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected invalid option, got %v", err)
+	}
+}
+
+func TestReconnectingClientFlapping(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	rc, err := NewReconnectingClient(flappingDial(&dials), ReconnectOptions{
+		MinRedial: 20 * time.Millisecond,
+		MaxRedial: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	rc.Close()
+	if n := atomic.LoadInt32(&dials); n > 8 {
+		t.Errorf("Expected backoff between short sessions, got %v dials", n)
+	}
+}
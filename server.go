@@ -2,35 +2,56 @@
 package frames
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // ErrChannelsExhausted is returned when we've run out of channels.
 var ErrChannelsExhausted = errors.New("channels exhausted")
 
+var errNotListenerListener = errors.New("not a ListenerListener")
+
 type newconn struct {
 	c net.Conn
 	e error
 }
 
 type frameConnection struct {
-	c           net.Conn
-	channels    map[uint16]*frameChannel
-	newConns    chan newconn
-	egress      chan *FramePacket
-	closeMarker chan bool
-	lastChid    uint16
+	c            net.Conn
+	mu           sync.Mutex
+	channels     map[uint16]*frameChannel
+	quarantine   map[uint16]bool // closed, awaiting the client's ack
+	newConns     chan newconn
+	egress       chan *FramePacket
+	closeMarker  chan bool
+	closeOnce    sync.Once
+	cause        error
+	lastChid     uint16
+	info         Info
+	maxWriteLen  int
+	writeTimeout time.Duration
+	log          *slog.Logger
+	session      SessionInfo
+	hooks        hookRunner
+	metrics      *Metrics
+	limits       Limits
+	opens        *rateLimiter
+	protocols    []string
 }
 
 func (f *frameConnection) nextID() (uint16, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.lastChid++
 	for i := 0; i < 0xffff; i++ {
-		if _, taken := f.channels[f.lastChid]; !taken {
+		if _, taken := f.channels[f.lastChid]; !taken && !f.quarantine[f.lastChid] {
 			return f.lastChid, nil
 		}
 		f.lastChid++
@@ -46,22 +67,62 @@ func (f *frameConnection) Accept() (net.Conn, error) {
 		}
 		return c.c, c.e
 	case <-f.closeMarker:
+		if pe, ok := f.cause.(*ProtocolError); ok {
+			return nil, pe
+		}
 		return nil, io.EOF
 	}
 }
 
 func (f *frameConnection) Close() error {
-	select {
-	case <-f.closeMarker:
-		return nil // already closed
-	default:
-	}
+	return f.closeWith(nil)
+}
+
+// closeWith tears down the session because of the given cause (nil
+// for a local close).
+func (f *frameConnection) closeWith(cause error) error {
+	var err error
+	f.closeOnce.Do(func() {
+		f.cause = cause
+
+		f.mu.Lock()
+		channels := make([]*frameChannel, 0, len(f.channels))
+		for _, c := range f.channels {
+			channels = append(channels, c)
+		}
+		f.mu.Unlock()
 
-	for _, c := range f.channels {
-		c.Close()
+		for _, c := range channels {
+			c.closeWith(nil)
+		}
+		close(f.closeMarker)
+		err = f.c.Close()
+		f.hooks.sessionClosed(f.session, cause)
+		f.metrics.sessionClosed(cause)
+		unregisterSession(f)
+	})
+	return err
+}
+
+func (f *frameConnection) state() SessionState {
+	f.mu.Lock()
+	channels := make([]ChannelState, 0, len(f.channels))
+	for _, ch := range f.channels {
+		channels = append(channels, ch.state(ch.String(), ch.channel))
+	}
+	f.mu.Unlock()
+	sortChannels(channels)
+	return SessionState{
+		LocalAddr:  f.c.LocalAddr().String(),
+		RemoteAddr: f.c.RemoteAddr().String(),
+		Server:     true,
+		Info: Info{
+			BytesRead:    atomic.LoadUint64(&f.info.BytesRead),
+			BytesWritten: atomic.LoadUint64(&f.info.BytesWritten),
+			ChannelsOpen: len(channels),
+		},
+		Channels: channels,
 	}
-	close(f.closeMarker)
-	return f.c.Close()
 }
 
 func (f *frameConnection) Addr() net.Addr {
@@ -69,6 +130,10 @@ func (f *frameConnection) Addr() net.Addr {
 }
 
 func (f *frameConnection) openChannel(pkt *FramePacket) {
+	if reason := f.overLimit(); reason != "" {
+		f.refuseOpen(reason)
+		return
+	}
 	chid, err := f.nextID()
 	response := &FramePacket{
 		Cmd:     pkt.Cmd,
@@ -76,25 +141,37 @@ func (f *frameConnection) openChannel(pkt *FramePacket) {
 		Channel: chid,
 		rch:     make(chan error, 1),
 	}
+	proto := negotiate(pkt.Data, f.protocols)
 	nc := newconn{}
 	if err == nil {
-		f.channels[chid] = &frameChannel{
-			conn:        f,
-			channel:     chid,
-			incoming:    make(chan []byte),
-			current:     nil,
-			closeMarker: make(chan bool),
+		ch := &frameChannel{
+			conn:         f,
+			channel:      chid,
+			incoming:     make(chan []byte),
+			current:      nil,
+			closeMarker:  make(chan bool),
+			channelStats: newChannelStats(),
 		}
-		nc.c = f.channels[chid]
+		ch.protocol = proto
+		ch.closeAck = offersCloseAck(pkt.Data)
+		response.Data = answerOffer(proto, ch.closeAck)
+		f.mu.Lock()
+		f.channels[chid] = ch
+		f.mu.Unlock()
+		f.hooks.channelOpened(ch.info(f.session, chid))
+		f.metrics.channelOpened()
+		nc.c = ch
 	} else {
 		response.Status = FrameError
 		nc.e = err
+		f.hooks.openRejected(f.session, err)
+		f.metrics.openRejected()
 	}
 	select {
 	case f.egress <- response:
 	case <-f.closeMarker:
 		nc.c = nil
-		nc.e = errClosedConn
+		nc.e = ErrSessionClosed
 	}
 	select {
 	case f.newConns <- nc:
@@ -103,63 +180,95 @@ func (f *frameConnection) openChannel(pkt *FramePacket) {
 }
 
 func (f *frameConnection) closeChannel(pkt *FramePacket) {
+	f.mu.Lock()
 	ch := f.channels[pkt.Channel]
+	delete(f.channels, pkt.Channel)
+	acked := f.quarantine[pkt.Channel]
+	delete(f.quarantine, pkt.Channel)
+	f.mu.Unlock()
+	if acked {
+		return
+	}
 	if ch == nil {
-		log.Printf("Closing a closed channel: %v", pkt)
+		f.log.Debug("closing a closed channel", pktAttrs(pkt))
+		f.metrics.strayFrame()
 		return
 	}
-	ch.Close()
-	delete(f.channels, pkt.Channel)
+	ch.closeWith(nil)
+	if !ch.closeAck {
+		return
+	}
+	// Acknowledge the client's close; the ID is free once the ack is
+	// on its way, as nothing more will follow it from the client.
+	select {
+	case f.egress <- &FramePacket{
+		Cmd:     FrameClose,
+		Channel: pkt.Channel,
+		rch:     make(chan error, 1),
+	}:
+	case <-f.closeMarker:
+	}
 }
 
 func (f *frameConnection) gotData(pkt *FramePacket) {
+	f.mu.Lock()
 	ch := f.channels[pkt.Channel]
+	quarantined := f.quarantine[pkt.Channel]
+	f.mu.Unlock()
 	if ch == nil {
-		log.Printf("Write to nonexistent channel on %v %v",
-			f.c.RemoteAddr(), pkt)
+		if quarantined {
+			f.log.Debug("write to closing channel", pktAttrs(pkt))
+		} else {
+			f.log.Warn("write to non-existent channel", pktAttrs(pkt))
+		}
+		f.metrics.strayFrame()
 		return
 	}
 	select {
 	case ch.incoming <- pkt.Data:
+		ch.countDelivered(len(pkt.Data))
 	case <-ch.closeMarker:
 	}
 }
 
 func (f *frameConnection) readLoop() {
-	defer f.Close()
+	var err error
+	defer func() { f.closeWith(err) }()
+	dec := NewDecoder(countingReader{f.c, &f.info.BytesRead})
 	for {
-		hdr := make([]byte, minPktLen)
-		_, err := io.ReadFull(f.c, hdr)
+		var pkt *FramePacket
+		pkt, err = dec.ReadPacket()
 		if err != nil {
-			if err != io.EOF {
-				log.Printf("Channel header read error: %v", err)
+			if err == io.EOF {
+				f.log.Debug("session ended")
+			} else {
+				f.log.Log(context.Background(), closeLevel(f.closeMarker),
+					"error reading packet", "err", err)
 			}
 			return
 		}
-		pkt := PacketFromHeader(hdr)
-		_, err = io.ReadFull(f.c, pkt.Data)
-		if err != nil {
-			log.Printf("Channel data read error: %v", err)
-			return
-		}
+		f.metrics.frameRead(pkt)
 
 		switch pkt.Cmd {
 		case FrameOpen:
-			f.openChannel(&pkt)
+			f.openChannel(pkt)
 		case FrameClose:
-			f.closeChannel(&pkt)
+			f.closeChannel(pkt)
 		case FrameData:
-			f.gotData(&pkt)
+			f.gotData(pkt)
 		default:
-			panic("unhandled msg")
+			err = protocolErrorf("unhandled command %v on channel %d",
+				pkt.Cmd, pkt.Channel)
+			f.log.Warn("error handling packet", pktAttrs(pkt), "err", err)
+			return
 		}
 	}
 }
 
 func (f *frameConnection) writeLoop() {
-	// Only close the underlying connection on return.  The read
-	// loop does the rest of the cleanup.
-	defer f.c.Close()
+	// Tear down the whole session on a write error so nothing
+	// stays blocked waiting on egress.
+	enc := NewEncoder(countingWriter{f.c, &f.info.BytesWritten})
 	for {
 		var e *FramePacket
 		select {
@@ -167,11 +276,16 @@ func (f *frameConnection) writeLoop() {
 		case <-f.closeMarker:
 			return
 		}
-		_, err := f.c.Write(e.Bytes())
+		setWriteDeadline(f.c, f.writeTimeout)
+		err := enc.WritePacket(e)
+		if err == nil {
+			f.metrics.frameWritten(e)
+		}
 		e.rch <- err
 		if err != nil {
-			log.Printf("Error writing to %v: %v",
-				f.c.RemoteAddr(), err)
+			f.log.Log(context.Background(), closeLevel(f.closeMarker),
+				"write error", pktAttrs(e), "err", err)
+			f.closeWith(err)
 			return
 		}
 	}
@@ -180,37 +294,106 @@ func (f *frameConnection) writeLoop() {
 // Listen for channeled connections across connections from the given
 // listener.
 func Listen(underlying net.Conn) (net.Listener, error) {
-	fc := frameConnection{
-		c:           underlying,
-		channels:    map[uint16]*frameChannel{},
-		newConns:    make(chan newconn),
-		egress:      make(chan *FramePacket),
-		closeMarker: make(chan bool),
+	return ListenWithOptions(underlying, ServerOptions{})
+}
+
+// ListenWithOptions listens for channeled connections on the given
+// connection, configured by the given options.
+func ListenWithOptions(underlying net.Conn, opts ServerOptions) (net.Listener, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return listen(underlying, opts.withDefaults())
+}
+
+func listen(underlying net.Conn, opts ServerOptions) (*frameConnection, error) {
+	if err := setKeepAlive(underlying, opts.KeepAlive); err != nil {
+		return nil, err
+	}
+	fc := &frameConnection{
+		c:            underlying,
+		channels:     map[uint16]*frameChannel{},
+		quarantine:   map[uint16]bool{},
+		newConns:     make(chan newconn, opts.AcceptBacklog),
+		egress:       make(chan *FramePacket, opts.EgressQueue),
+		closeMarker:  make(chan bool),
+		maxWriteLen:  opts.MaxFrameLen,
+		writeTimeout: opts.WriteTimeout,
+		log:          sessionLogger(opts.Logger, underlying),
+		session: SessionInfo{
+			LocalAddr:  underlying.LocalAddr(),
+			RemoteAddr: underlying.RemoteAddr(),
+			Server:     true,
+		},
+		hooks:     hookRunner{hooks: opts.Hooks},
+		metrics:   opts.Metrics,
+		limits:    opts.Limits,
+		opens:     newRateLimiter(opts.Limits.OpensPerSecond),
+		protocols: opts.Protocols,
 	}
+	fc.hooks.sessionEstablished(fc.session)
+	fc.metrics.sessionOpened()
+	registerSession(fc)
 	go fc.readLoop()
 	go fc.writeLoop()
-	return &fc, nil
+	if opts.ChannelIdleTimeout > 0 || opts.SessionIdleTimeout > 0 {
+		go fc.reapLoop(opts.ChannelIdleTimeout, opts.SessionIdleTimeout)
+	}
+	return fc, nil
 }
 
 type frameChannel struct {
-	conn        *frameConnection
-	channel     uint16
-	incoming    chan []byte
-	current     []byte
-	closeMarker chan bool
+	conn          *frameConnection
+	channel       uint16
+	incoming      chan []byte
+	current       []byte
+	closeMarker   chan bool
+	closeOnce     sync.Once
+	readDeadline  deadline
+	writeDeadline deadline
+	reason        error // why the channel was reset
+	closeAck      bool  // the client acknowledges closes
+	closedLocally atomic.Bool
+	channelStats
 }
 
+// Read returns data delivered before the channel closed, then EOF or
+// the reason it was reset.
 func (f *frameChannel) Read(b []byte) (n int, err error) {
-	if f.isClosed() {
-		return 0, errClosedReadCh
+	if len(f.current) == 0 && f.isClosed() {
+		return 0, f.closedReadErr()
 	}
 	n, f.current, err = channelRead(b, f.current, f.incoming,
-		f.closeMarker, f.conn.closeMarker)
+		f.closeMarker, f.conn.closeMarker, f.readDeadline.wait())
+	f.countRead(n)
+	if err == io.EOF && f.isClosed() {
+		if n > 0 {
+			// Report the close on the next Read.
+			err = nil
+		} else {
+			err = resetOr(f.reason, err)
+		}
+	}
 	return n, err
 }
 
+// closedReadErr is what reading returns once the channel's closed
+// and its data's drained.
+func (f *frameChannel) closedReadErr() error {
+	if f.closedLocally.Load() {
+		return resetOr(f.reason, errClosedReadCh)
+	}
+	return resetOr(f.reason, io.EOF)
+}
+
 func (f *frameChannel) Write(b []byte) (n int, err error) {
-	return channelWrite(b, f.channel, f.conn.egress, f.conn.closeMarker, nil)
+	n, err = channelWrite(b, f.channel, f.conn.maxWriteLen, f.conn.egress,
+		f.closeMarker, f.conn.closeMarker, f.writeDeadline.wait())
+	f.countWritten(n)
+	if err == errClosedWriteCh {
+		err = resetOr(f.reason, err)
+	}
+	return n, err
 }
 
 func (f *frameChannel) isClosed() bool {
@@ -222,12 +405,56 @@ func (f *frameChannel) isClosed() bool {
 	return false
 }
 
+// Close closes the channel, telling the client.  Its ID is
+// quarantined until the client acknowledges.
+//
+// Clients that don't acknowledge closes aren't told; the channel
+// keeps its ID until the client closes it too.
 func (f *frameChannel) Close() error {
-	if f == nil || f.isClosed() {
+	if f == nil {
+		return nil
+	}
+	f.closedLocally.Store(true)
+	if !f.closeAck {
+		return f.closeWith(nil)
+	}
+	c := f.conn
+	c.mu.Lock()
+	active := c.channels[f.channel] == f
+	if active {
+		delete(c.channels, f.channel)
+		c.quarantine[f.channel] = true
+	}
+	c.mu.Unlock()
+
+	f.closeWith(nil)
+	if active {
+		select {
+		case c.egress <- &FramePacket{
+			Cmd:     FrameClose,
+			Channel: f.channel,
+			rch:     make(chan error, 1),
+		}:
+		case <-c.closeMarker:
+		}
+	}
+	return nil
+}
+
+// closeWith closes the channel locally, recording why it was reset,
+// if it was.
+func (f *frameChannel) closeWith(reason error) error {
+	if f == nil {
 		return nil
 	}
 
-	close(f.closeMarker)
+	f.closeOnce.Do(func() {
+		f.reason = reason
+		close(f.closeMarker)
+		f.conn.hooks.channelClosed(f.info(f.conn.session, f.channel))
+		f.conn.metrics.channelClosed()
+		recordClosed(f.state(f.String(), f.channel))
+	})
 
 	return nil
 }
@@ -241,15 +468,19 @@ func (f *frameChannel) RemoteAddr() net.Addr {
 }
 
 func (f *frameChannel) SetDeadline(t time.Time) error {
-	return errNotImpl
+	f.readDeadline.set(t)
+	f.writeDeadline.set(t)
+	return nil
 }
 
 func (f *frameChannel) SetReadDeadline(t time.Time) error {
-	return errNotImpl
+	f.readDeadline.set(t)
+	return nil
 }
 
 func (f *frameChannel) SetWriteDeadline(t time.Time) error {
-	return errNotImpl
+	f.writeDeadline.set(t)
+	return nil
 }
 
 func (f *frameChannel) String() string {
@@ -261,6 +492,11 @@ type listenerListener struct {
 	ch          chan net.Conn
 	underlying  net.Listener
 	closeMarker chan bool
+	closeOnce   sync.Once
+	opts        ServerOptions
+	limiter     sessionLimiter
+	mu          sync.Mutex
+	sessions    map[*frameConnection]bool
 	err         error
 }
 
@@ -278,17 +514,20 @@ func (ll *listenerListener) closed() bool {
 }
 
 func (ll *listenerListener) Close() error {
-	if !ll.closed() {
-		close(ll.closeMarker)
-	}
+	ll.closeOnce.Do(func() { close(ll.closeMarker) })
 	return ll.underlying.Close()
 }
 
 func (ll *listenerListener) Accept() (net.Conn, error) {
 	select {
 	case c := <-ll.ch:
-		return c, ll.err
+		return c, nil
 	case <-ll.closeMarker:
+		ll.mu.Lock()
+		defer ll.mu.Unlock()
+		if ll.err != nil {
+			return nil, ll.err
+		}
 		return nil, io.EOF
 	}
 }
@@ -296,10 +535,25 @@ func (ll *listenerListener) Accept() (net.Conn, error) {
 func (ll *listenerListener) listenListen(c net.Conn) error {
 	defer c.Close()
 
-	l, err := Listen(c)
+	reason, release := ll.limiter.admit(c.RemoteAddr())
+	defer release()
+	if reason != "" {
+		refuseSession(c, reason, ll.opts.Logger)
+		return limitError(reason)
+	}
+
+	l, err := listen(c, ll.opts)
 	if err != nil {
 		return err
 	}
+	ll.mu.Lock()
+	ll.sessions[l] = true
+	ll.mu.Unlock()
+	defer func() {
+		ll.mu.Lock()
+		delete(ll.sessions, l)
+		ll.mu.Unlock()
+	}()
 	for {
 		c, err := l.Accept()
 		if err != nil {
@@ -319,23 +573,63 @@ func (ll *listenerListener) listen(l net.Listener) {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			ll.mu.Lock()
+			if ll.err == nil && !ll.closed() {
+				ll.err = err
+			}
+			ll.mu.Unlock()
 			ll.Close()
-			ll.err = err
 			return
 		}
 		go ll.listenListen(c)
 	}
 }
 
+// CloseSessions closes every session served by a listener from
+// ListenerListener.  Closing the listener itself only stops new
+// sessions and channels, so this finishes a graceful shutdown once
+// in-flight work has drained.
+func CloseSessions(l net.Listener) error {
+	ll, ok := l.(*listenerListener)
+	if !ok {
+		return errNotListenerListener
+	}
+	ll.mu.Lock()
+	sessions := make([]*frameConnection, 0, len(ll.sessions))
+	for s := range ll.sessions {
+		sessions = append(sessions, s)
+	}
+	ll.mu.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+	return nil
+}
+
 // ListenerListener is a listener that listens on a net.Listener and
 // returns framed connections opened from connections opened by the
 // underlying Listener.
+//
+// Once the underlying Listener fails, Accept returns its error.  After
+// Close, Accept returns io.EOF.
 func ListenerListener(l net.Listener) (net.Listener, error) {
+	return ListenerListenerWithOptions(l, ServerOptions{})
+}
+
+// ListenerListenerWithOptions is ListenerListener with each
+// underlying session configured by the given options.
+func ListenerListenerWithOptions(l net.Listener, opts ServerOptions) (net.Listener, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 	ll := &listenerListener{
-		make(chan net.Conn),
-		l,
-		make(chan bool),
-		nil}
+		ch:          make(chan net.Conn, opts.AcceptBacklog),
+		underlying:  l,
+		closeMarker: make(chan bool),
+		opts:        opts.withDefaults(),
+		limiter:     sessionLimiter{limits: opts.Limits},
+		sessions:    map[*frameConnection]bool{},
+	}
 
 	go ll.listen(l)
 
@@ -0,0 +1,201 @@
+package frames
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default for ServerOptions.SniffTimeout.
+const defaultSniffTimeout = 10 * time.Second
+
+// isFrames reports whether hdr, the first bytes of a connection, is
+// the header of a client's first FrameOpen.
+func isFrames(hdr []byte) bool {
+	return len(hdr) >= minPktLen && mayBeFrames(hdr[:minPktLen])
+}
+
+// mayBeFrames reports whether hdr, some of the first bytes of a
+// connection, could begin the header of a client's first FrameOpen.
+func mayBeFrames(hdr []byte) bool {
+	for i, b := range hdr {
+		switch i {
+		case 0:
+			if b > maxWriteLen>>8 {
+				return false
+			}
+		case 1:
+			if binary.BigEndian.Uint16(hdr) > maxWriteLen {
+				return false
+			}
+		case 2, 3:
+			if b != 0 {
+				return false
+			}
+		case 4:
+			if FrameCmd(b) != FrameOpen {
+				return false
+			}
+		case 5:
+			if FrameStatus(b) != FrameSuccess {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// A sniffedConn is a connection whose first bytes were peeked.
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// SetKeepAlive passes through to the underlying connection, so
+// ServerOptions.KeepAlive applies to sniffed connections.
+func (c *sniffedConn) SetKeepAlive(on bool) error {
+	if ka, ok := c.Conn.(keepAliver); ok {
+		return ka.SetKeepAlive(on)
+	}
+	return nil
+}
+
+// SetKeepAlivePeriod passes through to the underlying connection.
+func (c *sniffedConn) SetKeepAlivePeriod(d time.Duration) error {
+	if ka, ok := c.Conn.(keepAliver); ok {
+		return ka.SetKeepAlivePeriod(d)
+	}
+	return nil
+}
+
+// A connListener accepts connections handed to it by a sniffer.
+type connListener struct {
+	s           *sniffer
+	ch          chan net.Conn
+	closeMarker chan bool
+	closeOnce   sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ch:
+		return c, nil
+	case <-l.closeMarker:
+		return nil, io.EOF
+	}
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.s.l.Addr()
+}
+
+func (l *connListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeMarker)
+		err = l.s.closed()
+	})
+	return err
+}
+
+// hand gives c to whoever accepts from l, unless l is closed.
+func (l *connListener) hand(c net.Conn) {
+	select {
+	case l.ch <- c:
+	case <-l.closeMarker:
+		c.Close()
+	}
+}
+
+// A sniffer sorts connections from l by protocol.
+type sniffer struct {
+	l             net.Listener
+	timeout       time.Duration
+	frames, plain *connListener
+	mu            sync.Mutex
+	open          int
+}
+
+// closed closes the underlying listener once both sides are closed.
+func (s *sniffer) closed() error {
+	s.mu.Lock()
+	s.open--
+	last := s.open == 0
+	s.mu.Unlock()
+	if last {
+		return s.l.Close()
+	}
+	return nil
+}
+
+// sniff peeks at c's first bytes until they're a frames header, or
+// can't be one.
+func (s *sniffer) sniff(c net.Conn) {
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(s.timeout))
+	var hdr []byte
+	for n := 1; n <= minPktLen; n++ {
+		var err error
+		hdr, err = r.Peek(n)
+		if err != nil || !mayBeFrames(hdr) {
+			break
+		}
+	}
+	c.SetReadDeadline(time.Time{})
+	sc := &sniffedConn{c, r}
+	switch {
+	case isFrames(hdr):
+		s.frames.hand(sc)
+	case len(hdr) > 0:
+		s.plain.hand(sc)
+	default:
+		// Nothing arrived in time.
+		c.Close()
+	}
+}
+
+func (s *sniffer) listen() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			s.frames.Close()
+			s.plain.Close()
+			return
+		}
+		go s.sniff(c)
+	}
+}
+
+// SniffListener serves frames and another protocol, such as plain
+// HTTP, on one listener.  Each connection accepted from l is sorted
+// by its first bytes: channels of frames sessions are accepted from
+// framesL, as with ListenerListenerWithOptions, and other connections
+// from plain.
+//
+// A connection is plain as soon as its first bytes can't begin a
+// frames header.  One that's sent only part of a frames header waits
+// up to opts.SniffTimeout for the rest, and is plain if it doesn't
+// arrive.
+//
+// l is closed once both returned listeners are.
+func SniffListener(l net.Listener, opts ServerOptions) (framesL, plain net.Listener, err error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	s := &sniffer{l: l, timeout: opts.withDefaults().SniffTimeout, open: 2}
+	s.frames = &connListener{s: s, ch: make(chan net.Conn), closeMarker: make(chan bool)}
+	s.plain = &connListener{s: s, ch: make(chan net.Conn), closeMarker: make(chan bool)}
+	framesL, err = ListenerListenerWithOptions(s.frames, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	go s.listen()
+	return framesL, s.plain, nil
+}
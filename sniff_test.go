@@ -0,0 +1,196 @@
+package frames
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsFrames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"\x00\x00\x00\x00\x00\x00", true},
+		{"\x00\x04\x00\x00\x00\x00", true},
+		{"\x00\x00\x00\x00\x02\x00", false},
+		{"\x00\x00\x00\x01\x00\x00", false},
+		{"GET / HTTP/1.1\r\n", false},
+		{"\x16\x03\x01\x02\x00\x01", false},
+		{"\x00\x00", false},
+	}
+	for _, test := range tests {
+		if got := isFrames([]byte(test.in)); got != test.want {
+			t.Errorf("isFrames(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSniffListener(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	fl, pl, err := SniffListener(l, ServerOptions{Logger: discardLogger})
+	if err != nil {
+		t.Fatalf("Error sniffing: %v", err)
+	}
+	defer fl.Close()
+	defer pl.Close()
+
+	go http.Serve(pl, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "plain")
+	}))
+	go func() {
+		for {
+			c, err := fl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	res, err := http.Get("http://" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Error getting: %v", err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "plain" {
+		t.Errorf("Expected plain, got %q", b)
+	}
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	d, err := NewClientWithOptions(c, ClientOptions{Logger: discardLogger})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer d.Close()
+	for i := 0; i < 2; i++ {
+		ch, err := d.Dial()
+		if err != nil {
+			t.Fatalf("Error opening channel: %v", err)
+		}
+		io.WriteString(ch, "frames\n")
+		if line, err := bufio.NewReader(ch).ReadString('\n'); err != nil || line != "frames\n" {
+			t.Errorf("Expected echo, got %q, %v", line, err)
+		}
+		ch.Close()
+	}
+
+	// The underlying listener stays open until both sides are closed.
+	pl.Close()
+	if _, err := net.Dial("tcp", l.Addr().String()); err != nil {
+		t.Errorf("Expected listener still open, got %v", err)
+	}
+	fl.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Errorf("Expected underlying listener closed")
+	}
+}
+
+func TestMayBeFrames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", true},
+		{"\x00", true},
+		{"\x00\x04\x00", true},
+		{"GE", true},
+		{"GET", false},
+		{"\x80", true},
+		{"\x81", false},
+		{"\x80\x01", false},
+		{"\x00\x00\x01", false},
+		{"\x00\x00\x00\x00\x02", false},
+	}
+	for _, test := range tests {
+		if got := mayBeFrames([]byte(test.in)); got != test.want {
+			t.Errorf("mayBeFrames(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSniffListenerShortPlain(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	fl, pl, err := SniffListener(l, ServerOptions{Logger: discardLogger, SniffTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("Error sniffing: %v", err)
+	}
+	defer fl.Close()
+	defer pl.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer c.Close()
+	io.WriteString(c, "hi\n")
+
+	pc, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting plain: %v", err)
+	}
+	defer pc.Close()
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(pc, b); err != nil || string(b) != "hi\n" {
+		t.Errorf("Expected hi, got %q, %v", b, err)
+	}
+}
+
+type keepAliveConn struct {
+	net.Conn
+	on     bool
+	period time.Duration
+}
+
+func (c *keepAliveConn) SetKeepAlive(on bool) error {
+	c.on = on
+	return nil
+}
+
+func (c *keepAliveConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.period = d
+	return nil
+}
+
+func TestSniffedConnKeepAlive(t *testing.T) {
+	kc := &keepAliveConn{}
+	if err := setKeepAlive(&sniffedConn{Conn: kc}, time.Minute); err != nil {
+		t.Fatalf("Error setting keepalive: %v", err)
+	}
+	if !kc.on || kc.period != time.Minute {
+		t.Errorf("Expected keepalive of a minute, got %v, %v", kc.on, kc.period)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if err := setKeepAlive(&sniffedConn{Conn: c1}, time.Minute); err != nil {
+		t.Errorf("Expected keepalive ignored without support, got %v", err)
+	}
+}
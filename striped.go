@@ -0,0 +1,323 @@
+package frames
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoSessions is returned when a dialer has no live sessions to
+// open a channel on.
+var ErrNoSessions = errors.New("no live sessions")
+
+// Each open channel counts as this many bytes in flight when choosing
+// the least loaded session.
+const channelLoad = maxWriteLen
+
+// StripedOptions configure a striped client created with
+// NewStripedClient.
+type StripedOptions struct {
+	// Sessions is the number of underlying sessions.  Default is 4.
+	Sessions int
+	// Client configures each underlying session.
+	Client ClientOptions
+	// MinRedial and MaxRedial bound the exponential backoff when
+	// replacing a dead session.  Defaults are 100ms and 30s.
+	MinRedial time.Duration
+	MaxRedial time.Duration
+	// MinUptime is how long a session must stay up before the
+	// backoff is reset.  Sessions dying sooner are replaced after
+	// the current backoff.  Default is 5s.
+	MinUptime time.Duration
+}
+
+func (o StripedOptions) validate() error {
+	if o.Sessions < 0 {
+		return invalidOption("Sessions", o.Sessions)
+	}
+	if o.MinRedial < 0 {
+		return invalidOption("MinRedial", o.MinRedial)
+	}
+	if o.MaxRedial < 0 || (o.MaxRedial > 0 && o.MaxRedial < o.MinRedial) {
+		return invalidOption("MaxRedial", o.MaxRedial)
+	}
+	if o.MinUptime < 0 {
+		return invalidOption("MinUptime", o.MinUptime)
+	}
+	return o.Client.validate()
+}
+
+func (o StripedOptions) withDefaults() StripedOptions {
+	if o.Sessions == 0 {
+		o.Sessions = 4
+	}
+	if o.MinRedial == 0 {
+		o.MinRedial = 100 * time.Millisecond
+	}
+	if o.MaxRedial == 0 {
+		o.MaxRedial = 30 * time.Second
+	}
+	if o.MinUptime == 0 {
+		o.MinUptime = defaultMinUptime
+	}
+	return o
+}
+
+// Sessions staying up at least this long reset redial backoff.
+const defaultMinUptime = 5 * time.Second
+
+type stripedClient struct {
+	dial        func(context.Context) (net.Conn, error)
+	opts        StripedOptions
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	sessions    []*frameClient // nil while being replaced
+	retired     Info
+	closeMarker chan bool
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewStripedClient returns a ChannelDialer that spreads channels over
+// several sessions, each on its own connection from dial.  New
+// channels are opened on the least loaded session, by open channels
+// and bytes being written.  Dead sessions are replaced in the
+// background.
+//
+// An error is returned only if no session could be established.
+func NewStripedClient(dial func(context.Context) (net.Conn, error),
+	opts StripedOptions) (ChannelDialer, error) {
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := &stripedClient{
+		dial:        dial,
+		opts:        opts,
+		ctx:         ctx,
+		cancel:      cancel,
+		sessions:    make([]*frameClient, opts.Sessions),
+		closeMarker: make(chan bool),
+	}
+
+	var firstErr error
+	live := 0
+	for i := range sc.sessions {
+		fc, err := sc.connect()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			sc.wg.Add(1)
+			go sc.replace(i, nil)
+			continue
+		}
+		live++
+		sc.sessions[i] = fc
+		sc.wg.Add(1)
+		go sc.replace(i, fc)
+	}
+	if live == 0 {
+		sc.Close()
+		return nil, firstErr
+	}
+	return sc, nil
+}
+
+func (sc *stripedClient) connect() (*frameClient, error) {
+	c, err := sc.dial(sc.ctx)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewClientWithOptions(c, sc.opts.Client)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return d.(*frameClient), nil
+}
+
+// replace waits for the session in the given slot to die, then
+// dials a new one to take its place, until the client is closed.
+func (sc *stripedClient) replace(slot int, fc *frameClient) {
+	defer sc.wg.Done()
+	delay := sc.opts.MinRedial
+	up := time.Now()
+	for {
+		if fc != nil {
+			select {
+			case <-fc.closeMarker:
+			case <-sc.closeMarker:
+				return
+			}
+			sc.mu.Lock()
+			sc.sessions[slot] = nil
+			i := fc.GetInfo()
+			sc.retired.BytesRead += i.BytesRead
+			sc.retired.BytesWritten += i.BytesWritten
+			sc.mu.Unlock()
+			fc = nil
+			if time.Since(up) >= sc.opts.MinUptime {
+				delay = sc.opts.MinRedial
+			} else if !sleepBackoff(&delay, sc.opts.MaxRedial, sc.closeMarker) {
+				return
+			}
+		}
+
+		nfc, err := sc.connect()
+		if err != nil {
+			if !sleepBackoff(&delay, sc.opts.MaxRedial, sc.closeMarker) {
+				return
+			}
+			continue
+		}
+
+		sc.mu.Lock()
+		select {
+		case <-sc.closeMarker:
+			sc.mu.Unlock()
+			nfc.Close()
+			return
+		default:
+		}
+		sc.sessions[slot] = nfc
+		sc.mu.Unlock()
+		fc = nfc
+		up = time.Now()
+	}
+}
+
+// sleepBackoff waits a jittered *delay, then advances *delay toward
+// max.  It returns false if stop closed first.
+func sleepBackoff(delay *time.Duration, max time.Duration, stop <-chan bool) bool {
+	t := time.NewTimer(jitter(*delay))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-stop:
+		return false
+	}
+	*delay = backoff(*delay, max)
+	return true
+}
+
+// jitter returns a random duration within 50% of d, so retries
+// backing off together spread out.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// backoff returns the delay to use after d in an exponential
+// backoff, doubling up to max.
+func backoff(d, max time.Duration) time.Duration {
+	d *= 2
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (fc *frameClient) load() int64 {
+	fc.mu.Lock()
+	open := len(fc.channels)
+	fc.mu.Unlock()
+	return int64(open)*channelLoad + atomic.LoadInt64(&fc.pending)
+}
+
+// pick returns live sessions ordered from least to most loaded.
+func (sc *stripedClient) pick() []*frameClient {
+	sc.mu.Lock()
+	var rv []*frameClient
+	for _, fc := range sc.sessions {
+		if fc != nil && !fc.closed() {
+			rv = append(rv, fc)
+		}
+	}
+	sc.mu.Unlock()
+
+	loads := make(map[*frameClient]int64, len(rv))
+	for _, fc := range rv {
+		loads[fc] = fc.load()
+	}
+	// Insertion sort; there aren't many.
+	for i := 1; i < len(rv); i++ {
+		for j := i; j > 0 && loads[rv[j]] < loads[rv[j-1]]; j-- {
+			rv[j], rv[j-1] = rv[j-1], rv[j]
+		}
+	}
+	return rv
+}
+
+func (sc *stripedClient) Dial() (net.Conn, error) {
+	select {
+	case <-sc.closeMarker:
+		return nil, ErrSessionClosed
+	default:
+	}
+
+	err := ErrNoSessions
+	var limited error
+	for _, fc := range sc.pick() {
+		var c net.Conn
+		c, err = fc.Dial()
+		if err == nil {
+			return c, nil
+		}
+		switch {
+		case errors.Is(err, ErrLimitExceeded):
+			// This session's full; another may not be.
+			limited = err
+		case !fc.closed():
+			// The server refused; another session won't do better.
+			return nil, err
+		}
+	}
+	if limited != nil {
+		return nil, limited
+	}
+	return nil, err
+}
+
+func (sc *stripedClient) GetInfo() Info {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	rv := sc.retired
+	for _, fc := range sc.sessions {
+		if fc == nil {
+			continue
+		}
+		i := fc.GetInfo()
+		rv.BytesRead += i.BytesRead
+		rv.BytesWritten += i.BytesWritten
+		rv.ChannelsOpen += i.ChannelsOpen
+	}
+	return rv
+}
+
+func (sc *stripedClient) Close() error {
+	sc.closeOnce.Do(func() {
+		close(sc.closeMarker)
+		sc.cancel()
+		sc.mu.Lock()
+		sessions := append([]*frameClient(nil), sc.sessions...)
+		sc.mu.Unlock()
+		for _, fc := range sessions {
+			if fc != nil {
+				fc.Close()
+			}
+		}
+	})
+	sc.wg.Wait()
+	return nil
+}
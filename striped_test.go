@@ -0,0 +1,174 @@
+package frames
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStripedClient(t *testing.T) {
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	tc := runTestEchoServer(t)
+	defer tc.l.Close()
+
+	var d net.Dialer
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", tc.addr)
+	}
+
+	scd, err := NewStripedClient(dial, StripedOptions{
+		Sessions:  3,
+		MinRedial: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Error creating striped client: %v", err)
+	}
+	defer scd.Close()
+	sc := scd.(*stripedClient)
+
+	var chans []net.Conn
+	for i := 0; i < 6; i++ {
+		c, err := sc.Dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		chans = append(chans, c)
+	}
+	for i, fc := range sc.sessions {
+		if n := fc.GetInfo().ChannelsOpen; n != 2 {
+			t.Errorf("Expected 2 channels on session %d, got %d", i, n)
+		}
+	}
+	if n := sc.GetInfo().ChannelsOpen; n != 6 {
+		t.Errorf("Expected 6 channels open in total, got %v", n)
+	}
+
+	// Kill a session and wait for its replacement.
+	sc.mu.Lock()
+	dead := sc.sessions[1]
+	sc.mu.Unlock()
+	dead.c.Close()
+	for {
+		sc.mu.Lock()
+		replacement := sc.sessions[1]
+		sc.mu.Unlock()
+		if replacement != nil && replacement != dead {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The replacement is the least loaded.
+	c, err := sc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing after replacement: %v", err)
+	}
+	if c.(*clientChannel).fc != sc.sessions[1] {
+		t.Errorf("Expected new channel on the replacement session")
+	}
+	for _, c := range chans {
+		c.Close()
+	}
+}
+
+func TestStripedClientNoServer(t *testing.T) {
+	t.Parallel()
+	errNope := errors.New("nope")
+	_, err := NewStripedClient(func(context.Context) (net.Conn, error) {
+		return nil, errNope
+	}, StripedOptions{Sessions: 2})
+	if err != errNope {
+		t.Errorf("Expected dial error, got %v", err)
+	}
+
+	_, err = NewStripedClient(nil, StripedOptions{MinRedial: time.Second, MaxRedial: time.Millisecond})
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected invalid option, got %v", err)
+	}
+}
+
+// flappingDial returns a dial function whose connections are dropped
+// as soon as they're established, counting dials in n.
+func flappingDial(n *int32) func(context.Context) (net.Conn, error) {
+	return func(context.Context) (net.Conn, error) {
+		atomic.AddInt32(n, 1)
+		c, s := net.Pipe()
+		s.Close()
+		return c, nil
+	}
+}
+
+func TestStripedClientFlapping(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	sc, err := NewStripedClient(flappingDial(&dials), StripedOptions{
+		Sessions:  1,
+		MinRedial: 20 * time.Millisecond,
+		MaxRedial: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Error creating striped client: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	sc.Close()
+	// 20ms, 40ms, 80ms, 160ms (each jittered) fit in 300ms.
+	if n := atomic.LoadInt32(&dials); n > 8 {
+		t.Errorf("Expected backoff between short sessions, got %v dials", n)
+	}
+}
+
+func TestStripedClientLimited(t *testing.T) {
+	t.Parallel()
+	defer time.AfterFunc(time.Second*5, func() {
+		panic("Taking too long")
+	}).Stop()
+	addr, _ := runServerWithOptions(t, ServerOptions{Limits: Limits{ChannelsPerSession: 1}})
+
+	var d net.Dialer
+	scd, err := NewStripedClient(func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}, StripedOptions{Sessions: 2})
+	if err != nil {
+		t.Fatalf("Error creating striped client: %v", err)
+	}
+	defer scd.Close()
+	sc := scd.(*stripedClient)
+
+	first, err := sc.Dial()
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer first.Close()
+
+	// Make the full session look least loaded, so it's tried first.
+	var other *frameClient
+	sc.mu.Lock()
+	for _, fc := range sc.sessions {
+		if fc != first.(*clientChannel).fc {
+			other = fc
+		}
+	}
+	sc.mu.Unlock()
+	atomic.AddInt64(&other.pending, 10*channelLoad)
+	c, err := sc.Dial()
+	atomic.AddInt64(&other.pending, -10*channelLoad)
+	if err != nil {
+		t.Fatalf("Expected a full session to be skipped, got %v", err)
+	}
+	defer c.Close()
+	if c.(*clientChannel).fc != other {
+		t.Errorf("Expected the channel on the other session")
+	}
+
+	if c, err := sc.Dial(); !errors.Is(err, ErrLimitExceeded) {
+		if c != nil {
+			c.Close()
+		}
+		t.Errorf("Expected limit exceeded with every session full, got %v", err)
+	}
+}